@@ -0,0 +1,272 @@
+package redis
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kexirong/coredns-redis/store"
+	"github.com/miekg/dns"
+)
+
+// maxBodySize limits the size of an RRset written through the API.
+const maxBodySize = 1 << 20
+
+// api serves the management API, reading and writing the RRsets of the zones
+// of the plugin through its store:
+//
+//	GET    /zones                              the zones
+//	GET    /zones/ZONE/records                 the RRsets of all names of ZONE
+//	GET    /zones/ZONE/records/NAME            the RRsets of NAME
+//	GET    /zones/ZONE/records/NAME/TYPE       an RRset
+//	PUT    /zones/ZONE/records/NAME/TYPE       create or replace an RRset
+//	DELETE /zones/ZONE/records/NAME[/TYPE]     delete an RRset, or all of NAME
+type api struct {
+	redis *Redis
+	addr  string
+	token string
+	srv   *http.Server
+}
+
+func newAPI(r *Redis, addr, token string) *api {
+	return &api{redis: r, addr: addr, token: token}
+}
+
+func (a *api) start() error {
+	ln, err := net.Listen("tcp", a.addr)
+	if err != nil {
+		return err
+	}
+	a.srv = &http.Server{Handler: a, ReadTimeout: 10 * time.Second, WriteTimeout: time.Minute}
+	go func() {
+		if err := a.srv.Serve(ln); err != http.ErrServerClosed {
+			log.Errorf("api: %s", err)
+		}
+	}()
+	return nil
+}
+
+func (a *api) shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return a.srv.Shutdown(ctx)
+}
+
+func (a *api) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte("Bearer "+a.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		apiError(w, http.StatusUnauthorized, errors.New("invalid token"))
+		return
+	}
+
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if parts[0] != "zones" || len(parts) == 2 || len(parts) > 5 || len(parts) > 2 && parts[2] != "records" {
+		apiError(w, http.StatusNotFound, errors.New("no such resource"))
+		return
+	}
+	if len(parts) == 1 {
+		if req.Method != http.MethodGet {
+			apiError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		apiJSON(w, http.StatusOK, a.zones())
+		return
+	}
+
+	zone := ""
+	for _, z := range a.zones() {
+		if strings.EqualFold(z, dns.Fqdn(parts[1])) {
+			zone = z
+		}
+	}
+	if zone == "" {
+		apiError(w, http.StatusNotFound, errors.New("no such zone"))
+		return
+	}
+
+	var name, field string
+	if len(parts) > 3 {
+		name = dns.Fqdn(parts[3])
+		if _, ok := dns.IsDomainName(name); !ok || !dns.IsSubDomain(zone, name) {
+			apiError(w, http.StatusNotFound, errors.New(name+" is not in "+zone))
+			return
+		}
+		if a.redis.route(name) != nil {
+			apiError(w, http.StatusNotFound, errors.New(name+" is served by a backend"))
+			return
+		}
+	}
+	if len(parts) > 4 {
+		field = strings.ToUpper(parts[4])
+	}
+
+	ctx := req.Context()
+	switch {
+	case req.Method == http.MethodGet && name == "":
+		a.getZone(ctx, w, zone)
+	case req.Method == http.MethodGet && field == "":
+		a.getName(ctx, w, zone, name)
+	case req.Method == http.MethodGet:
+		a.getRRset(ctx, w, zone, name, field)
+	case req.Method == http.MethodPut && field != "":
+		a.putRRset(ctx, w, req, zone, name, field)
+	case req.Method == http.MethodDelete && name != "":
+		a.deleteRRset(ctx, w, zone, name, field)
+	default:
+		apiError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+// zones returns the zones of the plugin not served by a backend, the API
+// only manages those of its own redis.
+func (a *api) zones() []string {
+	var zones []string
+	for _, zone := range a.redis.Zones {
+		if a.redis.route(zone) == nil {
+			zones = append(zones, zone)
+		}
+	}
+	return zones
+}
+
+func (a *api) getZone(ctx context.Context, w http.ResponseWriter, zone string) {
+	names := make(map[string]map[string]json.RawMessage)
+	err := a.redis.store.Walk(ctx, zone, func(key string, fields map[string]string) error {
+		name := keyName(key, a.redis.KeyPrefix)
+		names[name] = apiValues(fields, name, zone)
+		return nil
+	})
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	apiJSON(w, http.StatusOK, names)
+}
+
+func (a *api) getName(ctx context.Context, w http.ResponseWriter, zone, name string) {
+	fields, err := a.redis.store.Fields(ctx, Key(name, a.redis.KeyPrefix))
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if len(fields) == 0 {
+		apiError(w, http.StatusNotFound, errors.New("no records"))
+		return
+	}
+	apiJSON(w, http.StatusOK, apiValues(fields, name, zone))
+}
+
+func (a *api) getRRset(ctx context.Context, w http.ResponseWriter, zone, name, field string) {
+	val, err := a.redis.store.Get(ctx, Key(name, a.redis.KeyPrefix), field)
+	if err == store.ErrNotFound {
+		apiError(w, http.StatusNotFound, errors.New("no records"))
+		return
+	}
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	apiJSON(w, http.StatusOK, apiValue(field, val, name, zone))
+}
+
+// putRRset stores the RRset in the body: the JSON items of the type, or a
+// JSON string of zone file text.
+func (a *api) putRRset(ctx context.Context, w http.ResponseWriter, req *http.Request, zone, name, field string) {
+	if field == store.FieldFailover && a.redis.health == nil {
+		apiError(w, http.StatusBadRequest, errors.New(field+" needs health_check"))
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxBodySize))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	var val string
+	if err := json.Unmarshal(body, &val); err != nil {
+		var raw json.RawMessage
+		if err := json.Unmarshal(body, &raw); err != nil || !store.IsJSON(string(raw)) {
+			apiError(w, http.StatusBadRequest, errors.New("body is neither JSON items nor a string of zone file text"))
+			return
+		}
+		val = string(raw)
+	}
+
+	fields, err := a.redis.store.Fields(ctx, Key(name, a.redis.KeyPrefix))
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := store.CheckRRset(zone, name, field, val, fields); err != nil {
+		status := http.StatusBadRequest
+		if err == store.ErrConflict {
+			status = http.StatusConflict
+		}
+		apiError(w, status, err)
+		return
+	}
+
+	if err := a.redis.store.SetField(ctx, zone, name, field, val); err != nil {
+		status := http.StatusInternalServerError
+		switch err {
+		case store.ErrNotJSON:
+			status = http.StatusBadRequest
+		case store.ErrConflict:
+			status = http.StatusConflict
+		}
+		apiError(w, status, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *api) deleteRRset(ctx context.Context, w http.ResponseWriter, zone, name, field string) {
+	err := a.redis.store.DeleteField(ctx, zone, name, field)
+	if err == store.ErrNotFound {
+		apiError(w, http.StatusNotFound, errors.New("no records"))
+		return
+	}
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiValue returns the JSON of a field value: its JSON items, converting
+// zone file text and wire format values, or else a string of zone file text.
+func apiValue(field, val, name, zone string) json.RawMessage {
+	if !store.IsJSON(val) {
+		if rrs, err := store.DecodeValue(field, val, name, zone); err == nil {
+			val, _ = store.EncodeRecords(rrs, store.EncodingJSON)
+		}
+	}
+	if !store.IsJSON(val) || !json.Valid([]byte(val)) {
+		b, _ := json.Marshal(val)
+		return b
+	}
+	return json.RawMessage(val)
+}
+
+func apiValues(fields map[string]string, name, zone string) map[string]json.RawMessage {
+	values := make(map[string]json.RawMessage, len(fields))
+	for field, val := range fields {
+		values[field] = apiValue(field, val, name, zone)
+	}
+	return values
+}
+
+func apiJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func apiError(w http.ResponseWriter, status int, err error) {
+	apiJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,115 @@
+package redis
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kexirong/coredns-redis/store"
+	"github.com/miekg/dns"
+)
+
+func TestAPIRouting(t *testing.T) {
+	a := newAPI(&Redis{Zones: []string{"example.net."}}, ":0", "secret")
+
+	tests := []struct {
+		method, path, token string
+		status              int
+	}{
+		{"GET", "/zones", "", http.StatusUnauthorized},
+		{"GET", "/zones", "wrong", http.StatusUnauthorized},
+		{"GET", "/zones", "secret", http.StatusOK},
+		{"POST", "/zones", "secret", http.StatusMethodNotAllowed},
+		{"GET", "/zones/example.org./records", "secret", http.StatusNotFound},
+		{"GET", "/zones/example.net./names", "secret", http.StatusNotFound},
+		{"GET", "/zones/example.net./records/www.example.org./A", "secret", http.StatusNotFound},
+		{"PUT", "/zones/example.net./records/www.example.net.", "secret", http.StatusMethodNotAllowed},
+		// Without health_check.
+		{"PUT", "/zones/example.net./records/www.example.net./FAILOVER", "secret", http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s %s: expected status %d, got %d", tc.method, tc.path, tc.status, w.Code)
+		}
+	}
+}
+
+func TestAPIBackendZones(t *testing.T) {
+	r, mr := testRedis(t)
+	r.Zones = []string{"example.net.", "example.org."}
+	r.backends = []*Redis{
+		{Zones: []string{"unit.example.net."}, backend: "unit.example.net."},
+		{Zones: []string{"example.org."}, backend: "example.org."},
+	}
+	a := newAPI(r, ":0", "secret")
+	mr.HSet(Key("www.unit.example.net.", "coredns"), "A", `[{"ttl":30,"ip":"192.0.2.1"}]`)
+
+	tests := []struct {
+		method, path string
+		status       int
+	}{
+		{"GET", "/zones/example.net./records", http.StatusOK},
+		{"GET", "/zones/example.org./records", http.StatusNotFound},
+		{"GET", "/zones/example.net./records/www.unit.example.net.", http.StatusNotFound},
+		{"PUT", "/zones/example.net./records/www.unit.example.net./A", http.StatusNotFound},
+		{"DELETE", "/zones/example.net./records/www.unit.example.net./A", http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(`[{"ttl":30,"ip":"192.0.2.2"}]`))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s %s: expected status %d, got %d", tc.method, tc.path, tc.status, w.Code)
+		}
+	}
+	if val := mr.HGet(Key("www.unit.example.net.", "coredns"), "A"); val != `[{"ttl":30,"ip":"192.0.2.1"}]` {
+		t.Errorf("a backend name was written: %s", val)
+	}
+
+	req := httptest.NewRequest("GET", "/zones", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, req)
+	if body := strings.TrimSpace(w.Body.String()); body != `["example.net."]` {
+		t.Errorf("expected the own zone alone, got %s", body)
+	}
+}
+
+func TestAPIBumpsSerial(t *testing.T) {
+	r, mr := testRedis(t)
+	a := newAPI(r, ":0", "secret")
+	mr.HSet(Key("example.net.", "coredns"), "SOA", `{"ns":"ns1.example.net.","mbox":"hostmaster.example.net.","serial":2024010101,"minttl":30}`)
+
+	serial := func() uint32 {
+		val := mr.HGet(Key("example.net.", "coredns"), "SOA")
+		rrs, err := store.DecodeValue("SOA", val, "example.net.", "example.net.")
+		if err != nil || len(rrs) != 1 {
+			t.Fatalf("bad SOA %s (%v)", val, err)
+		}
+		return rrs[0].(*dns.SOA).Serial
+	}
+	before := serial()
+
+	for _, method := range []string{"PUT", "DELETE"} {
+		req := httptest.NewRequest(method, "/zones/example.net./records/www.example.net./A", strings.NewReader(`[{"ttl":30,"ip":"192.0.2.1"}]`))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, req)
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("%s: expected status %d, got %d: %s", method, http.StatusNoContent, w.Code, w.Body.String())
+		}
+		if after := serial(); after <= before {
+			t.Errorf("%s: expected a serial after %d, got %d", method, before, after)
+		} else {
+			before = after
+		}
+	}
+}
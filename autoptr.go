@@ -0,0 +1,185 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnsutil"
+	redisV8 "github.com/go-redis/redis/v8"
+	"github.com/kexirong/coredns-redis/store"
+	"github.com/miekg/dns"
+)
+
+const defaultAutoPTRInterval = 5 * time.Minute
+
+// autoPTR maintains a reverse index, address to names, of the A and AAAA
+// records below the forward zones. The index is a single hash shared by all
+// instances, and only one instance at a time rebuilds it.
+type autoPTR struct {
+	client   redisV8.UniversalClient
+	store    *store.Store
+	prefix   string
+	zones    []string
+	encoding string
+	interval time.Duration
+	owner    string
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newAutoPTR(s *store.Store, zones []string, interval time.Duration) *autoPTR {
+	var forward []string
+	for _, zone := range zones {
+		if dnsutil.IsReverse(zone) == 0 {
+			forward = append(forward, zone)
+		}
+	}
+	owner, _ := os.Hostname()
+	return &autoPTR{
+		client:   s.Client(),
+		store:    s,
+		prefix:   s.Prefix(),
+		encoding: s.Encoding(),
+		zones:    forward,
+		interval: interval,
+		owner:    owner + ":" + strconv.Itoa(os.Getpid()),
+	}
+}
+
+func (a *autoPTR) key() string { return store.InternalKey(a.prefix, "auto_ptr") }
+
+func (a *autoPTR) start() error {
+	a.stop = make(chan struct{})
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		a.run()
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-a.stop:
+				return
+			case <-ticker.C:
+				a.run()
+			}
+		}
+	}()
+	return nil
+}
+
+func (a *autoPTR) shutdown() error {
+	if a.stop != nil {
+		close(a.stop)
+		a.wg.Wait()
+		a.stop = nil
+	}
+	return nil
+}
+
+func (a *autoPTR) run() {
+	ctx, cancel := context.WithTimeout(context.Background(), a.interval)
+	defer cancel()
+
+	locked, err := a.client.SetNX(ctx, a.key()+":lock", a.owner, lockTTL(a.interval)).Result()
+	if err != nil || !locked {
+		return
+	}
+
+	index, err := a.build(ctx)
+	if err != nil {
+		return
+	}
+
+	values := make([]interface{}, 0, 2*len(index))
+	for ip, hosts := range index {
+		val, err := a.encode(hosts)
+		if err != nil {
+			continue
+		}
+		values = append(values, ip, val)
+	}
+
+	a.client.TxPipelined(ctx, func(pipe redisV8.Pipeliner) error {
+		pipe.Del(ctx, a.key())
+		if len(values) > 0 {
+			pipe.HSet(ctx, a.key(), values...)
+		}
+		return nil
+	})
+}
+
+func (a *autoPTR) encode(hosts RecordPTR) (string, error) {
+	if a.encoding != store.EncodingWire {
+		val, err := json.Marshal(hosts)
+		return string(val), err
+	}
+	records := make([]dns.RR, len(hosts))
+	for i, item := range hosts {
+		records[i] = item.NewPTR(".")
+	}
+	return store.PackWire(records)
+}
+
+// build walks the forward zones and returns the names of every address.
+func (a *autoPTR) build(ctx context.Context) (map[string]RecordPTR, error) {
+	index := make(map[string]RecordPTR)
+	for _, zone := range a.zones {
+		err := a.store.Walk(ctx, zone, func(key string, fields map[string]string) error {
+			a.add(ctx, index, zone, key, fields)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return index, nil
+}
+
+// add indexes the addresses of the A and AAAA fields of key, skipping items
+// outside of their window as of the build.
+func (a *autoPTR) add(ctx context.Context, index map[string]RecordPTR, zone, key string, fields map[string]string) {
+	if IsAnyKey(key) {
+		return
+	}
+	name := keyName(key, a.prefix)
+
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		field := dns.Type(qtype).String()
+		val, ok := fields[field]
+		if !ok {
+			continue
+		}
+		var items []ItemIP
+		if store.IsJSON(val) {
+			if err := unmarshal(ctx, field, val, &items); err != nil {
+				continue
+			}
+			items = activeIPs(items)
+		} else {
+			rrs, err := store.DecodeRaw(val, name, zone, qtype)
+			if err != nil {
+				continue
+			}
+			for _, rr := range rrs {
+				switch rr := rr.(type) {
+				case *dns.A:
+					items = append(items, ItemIP{TTL: rr.Hdr.Ttl, IP: rr.A})
+				case *dns.AAAA:
+					items = append(items, ItemIP{TTL: rr.Hdr.Ttl, IP: rr.AAAA})
+				}
+			}
+		}
+		for _, item := range items {
+			if item.IP == nil {
+				continue
+			}
+			index[item.IP.String()] = append(index[item.IP.String()], ItemHost{TTL: item.TTL, Host: name})
+		}
+	}
+}
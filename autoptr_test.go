@@ -0,0 +1,62 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestAutoPTR(t *testing.T) {
+	r, mr := testRedis(t)
+	mr.HSet(Key("www.example.net.", "coredns"), "A", `[{"ttl":30,"ip":"192.0.2.1"}]`)
+	mr.HSet(Key("mail.example.net.", "coredns"), "A", "30 IN A 192.0.2.1")
+	mr.HSet(Key("v6.example.net.", "coredns"), "AAAA", `[{"ttl":30,"ip":"2001:db8::1"}]`)
+	mr.HSet(Key("*.example.net.", "coredns"), "A", `[{"ttl":30,"ip":"192.0.2.9"}]`)
+	mr.HSet(Key("old.example.net.", "coredns"), "A", `[{"ttl":30,"ip":"192.0.2.1","not_after":"2000-01-01T00:00:00Z"}]`)
+
+	a := newAutoPTR(r.store, []string{"example.net.", "2.0.192.in-addr.arpa."}, time.Minute)
+	if len(a.zones) != 1 {
+		t.Fatalf("expected the forward zone only, got %v", a.zones)
+	}
+	a.run()
+	if ttl := mr.TTL(a.key() + ":lock"); ttl <= 0 || ttl >= a.interval {
+		t.Errorf("expected a lock shorter than the interval, got %s", ttl)
+	}
+
+	lookup := func(name string) []string {
+		records, err := r.ptrIndex(ctx, testState(name, dns.TypePTR), a.key())
+		if err == errKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var hosts []string
+		for _, rr := range records {
+			hosts = append(hosts, rr.(*dns.PTR).Ptr)
+		}
+		return hosts
+	}
+	if hosts := lookup("1.2.0.192.in-addr.arpa."); len(hosts) != 2 {
+		t.Errorf("expected www and mail but not the expired old, got %v", hosts)
+	}
+	if hosts := lookup("1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa."); len(hosts) != 1 || hosts[0] != "v6.example.net." {
+		t.Errorf("expected v6.example.net., got %v", hosts)
+	}
+	if hosts := lookup("9.2.0.192.in-addr.arpa."); hosts != nil {
+		t.Errorf("wildcard indexed: %v", hosts)
+	}
+
+	// The index is rebuilt once the lock of the previous run is gone.
+	mr.HSet(Key("new.example.net.", "coredns"), "A", `[{"ttl":30,"ip":"192.0.2.2"}]`)
+	a.run()
+	if hosts := lookup("2.2.0.192.in-addr.arpa."); hosts != nil {
+		t.Errorf("rebuilt while locked: %v", hosts)
+	}
+	mr.FastForward(lockTTL(a.interval))
+	a.run()
+	if hosts := lookup("2.2.0.192.in-addr.arpa."); len(hosts) != 1 {
+		t.Errorf("expected new.example.net., got %v", hosts)
+	}
+}
@@ -0,0 +1,31 @@
+package redis
+
+import "github.com/coredns/coredns/plugin"
+
+// route returns the backend serving name, the most specific one, or nil when
+// r serves it itself.
+func (r *Redis) route(name string) *Redis {
+	var (
+		best *Redis
+		zone = plugin.Zones(r.Zones).Matches(name)
+	)
+	for _, b := range r.backends {
+		bz := plugin.Zones(b.Zones).Matches(name)
+		// Backends may serve a zone of the plugin itself.
+		if bz != "" && len(bz) >= len(zone) {
+			best, zone = b, bz
+		}
+	}
+	return best
+}
+
+// ownZones returns the number of zones not served by a backend.
+func (r *Redis) ownZones() int {
+	n := 0
+	for _, zone := range r.Zones {
+		if b := r.route(zone); b == nil {
+			n++
+		}
+	}
+	return n
+}
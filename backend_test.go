@@ -0,0 +1,91 @@
+package redis
+
+import (
+	"testing"
+
+	"github.com/coredns/caddy"
+)
+
+func TestRoute(t *testing.T) {
+	r := &Redis{Zones: []string{"example.net.", "example.org."}}
+	unit := &Redis{Zones: []string{"unit.example.net."}, backend: "unit.example.net."}
+	org := &Redis{Zones: []string{"example.org."}, backend: "example.org."}
+	r.backends = []*Redis{unit, org}
+
+	tests := []struct {
+		name string
+		want *Redis
+	}{
+		{"www.example.net.", nil},
+		{"www.unit.example.net.", unit},
+		{"unit.example.net.", unit},
+		{"www.example.org.", org},
+	}
+	for _, tt := range tests {
+		if got := r.route(tt.name); got != tt.want {
+			t.Errorf("route(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if n := r.ownZones(); n != 1 {
+		t.Errorf("ownZones() = %d, want 1", n)
+	}
+}
+
+func TestParseBackend(t *testing.T) {
+	tests := []struct {
+		input string
+		ok    bool
+	}{
+		{`redis example.net example.org {
+			addresses 10.0.0.1:6379
+			backend unit.example.net {
+				addresses 10.1.0.1:6379
+				key_prefix unit
+				fallthrough
+			}
+			backend example.org {
+				addresses 10.2.0.1:6379
+			}
+		}`, true},
+		{"redis example.net {\n backend example.org {\n }\n}", false},
+		{"redis example.net {\n backend unit.example.net {\n api :8080 secret\n }\n}", false},
+		{"redis example.net {\n backend unit.example.net {\n backend x.unit.example.net {\n }\n }\n}", false},
+		{"redis example.net {\n backend unit.example.net {\n addresses 10.1.0.1:6379\n", false},
+		{"redis example.net {\n backend unit.example.net\n}", false},
+		// An option per line, and nothing after the braces.
+		{"redis example.net {\n backend unit.example.net { addresses 10.1.0.1:6379\n }\n}", false},
+		{"redis example.net {\n backend unit.example.net {\n legacy_keys }\n}", false},
+		{"redis example.net {\n backend unit.example.net {\n } fallthrough\n}", false},
+	}
+	for i, tt := range tests {
+		r, err := redisParse(caddy.NewTestController("dns", tt.input))
+		if (err == nil) != tt.ok {
+			t.Errorf("test %d: got %v, want ok %v", i, err, tt.ok)
+		}
+		if err != nil {
+			continue
+		}
+		if len(r.backends) != 2 || r.backends[0].KeyPrefix != "unit" || !r.backends[0].Fall.Through("www.unit.example.net.") {
+			t.Errorf("test %d: backends not parsed: %+v", i, r.backends)
+		}
+		if r.Client == nil || r.route("www.example.org.") != r.backends[1] {
+			t.Errorf("test %d: expected a client of the block and example.org. served by a backend", i)
+		}
+	}
+}
+
+func TestParseBackendsOnly(t *testing.T) {
+	r, err := redisParse(caddy.NewTestController("dns", "redis example.net {\n addresses 10.0.0.1:6379\n backend example.net {\n addresses 10.1.0.1:6379\n }\n}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Client != nil || r.conn != nil || r.backends[0].Client == nil {
+		t.Error("expected a client of the backend only")
+	}
+
+	_, err = redisParse(caddy.NewTestController("dns", "redis example.net {\n api :8080 secret\n backend example.net {\n }\n}"))
+	if err == nil {
+		t.Error("expected an error for api without zones of the block")
+	}
+}
@@ -0,0 +1,119 @@
+// Command coredns-redis manages the zones served by the redis plugin.
+//
+//	coredns-redis [-addr ADDRESSES] [-username USERNAME] [-password PASSWORD] [-prefix KEY_PREFIX] COMMAND ARGS...
+//
+// Commands:
+//
+//	import [-replace] [-dry-run] ZONE FILE   import a zone file into ZONE
+//	export ZONE                              write ZONE as a zone file to stdout
+//	convert ZONE...                          rewrite the JSON fields of the ZONEs in wire format
+//	validate ZONE...                         report problems with the data of the ZONEs
+//
+// All commands work on the default name_hash layout.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	redisV8 "github.com/go-redis/redis/v8"
+	"github.com/kexirong/coredns-redis/store"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:6379", "comma separated redis `addresses`")
+	username := flag.String("username", "", "redis `username`")
+	password := flag.String("password", "", "redis `password`")
+	prefix := flag.String("prefix", "", "key prefix, as key_prefix of the plugin")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	client := redisV8.NewUniversalClient(&redisV8.UniversalOptions{
+		Addrs:    strings.Split(*addr, ","),
+		Username: *username,
+		Password: *password,
+	})
+	defer client.Close()
+
+	s, err := store.New(client, *prefix, store.Options{})
+	if err != nil {
+		fatalf("%s", err)
+	}
+
+	ctx := context.Background()
+	args := flag.Args()[1:]
+
+	switch flag.Arg(0) {
+	case "import":
+		fs := flag.NewFlagSet("import", flag.ExitOnError)
+		replace := fs.Bool("replace", false, "remove the records of the zone missing from the file, instead of merging")
+		dryRun := fs.Bool("dry-run", false, "print the changes without applying them")
+		fs.Parse(args)
+		if fs.NArg() != 2 {
+			fatalf("usage: coredns-redis import [-replace] [-dry-run] ZONE FILE")
+		}
+		err = importZone(ctx, s, fs.Arg(0), fs.Arg(1), *replace, *dryRun, os.Stdout)
+
+	case "export":
+		if len(args) != 1 {
+			fatalf("usage: coredns-redis export ZONE")
+		}
+		err = exportZone(ctx, s, args[0], os.Stdout)
+
+	case "convert":
+		if len(args) == 0 {
+			fatalf("usage: coredns-redis convert ZONE...")
+		}
+		var n int
+		n, err = s.ConvertToWire(ctx, args...)
+		fmt.Printf("%d fields converted\n", n)
+
+	case "validate":
+		if len(args) == 0 {
+			fatalf("usage: coredns-redis validate ZONE...")
+		}
+		var problems []store.Problem
+		problems, err = s.Validate(ctx, args...)
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if err == nil && len(problems) > 0 {
+			os.Exit(1)
+		}
+
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fatalf("%s", err)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: coredns-redis [flags] COMMAND ARGS...
+
+commands:
+  import [-replace] [-dry-run] ZONE FILE   import a zone file into ZONE
+  export ZONE                              write ZONE as a zone file to stdout
+  convert ZONE...                          rewrite the JSON fields of the ZONEs in wire format
+  validate ZONE...                         report problems with the data of the ZONEs
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+func fatalf(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "coredns-redis: "+format+"\n", a...)
+	os.Exit(1)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	redisV8 "github.com/go-redis/redis/v8"
+	"github.com/kexirong/coredns-redis/keys"
+	"github.com/kexirong/coredns-redis/store"
+	"github.com/miekg/dns"
+)
+
+type change struct {
+	key, field string
+	old, new   string
+}
+
+// importZone imports the zone file file into zone. Records of the zone that
+// are not in the file are kept, unless replace is set. All changes are
+// applied in a single MULTI/EXEC transaction.
+func importZone(ctx context.Context, s *store.Store, zone, file string, replace, dryRun bool, out io.Writer) error {
+	zone = dns.Fqdn(zone)
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rrsets := make(map[string]map[string][]dns.RR)
+	zp := dns.NewZoneParser(f, zone, file)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		if !dns.IsSubDomain(zone, rr.Header().Name) {
+			return fmt.Errorf("%s is not in %s", rr.Header().Name, zone)
+		}
+		key := s.Key(rr.Header().Name)
+		field := dns.Type(rr.Header().Rrtype).String()
+		if rrsets[key] == nil {
+			rrsets[key] = make(map[string][]dns.RR)
+		}
+		rrsets[key][field] = append(rrsets[key][field], rr)
+	}
+	if err := zp.Err(); err != nil {
+		return err
+	}
+
+	existing, err := s.ReadZone(ctx, zone)
+	if err != nil {
+		return err
+	}
+
+	var changes []change
+	for key, fields := range rrsets {
+		for field, rrs := range fields {
+			val, err := store.EncodeRecords(rrs, store.EncodingJSON)
+			if err != nil {
+				return err
+			}
+			if old := existing[key][field]; old != val {
+				changes = append(changes, change{key: key, field: field, old: old, new: val})
+			}
+		}
+	}
+	if replace {
+		for key, fields := range existing {
+			for field, old := range fields {
+				if _, ok := rrsets[key][field]; !ok {
+					changes = append(changes, change{key: key, field: field, old: old})
+				}
+			}
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].key != changes[j].key {
+			return changes[i].key < changes[j].key
+		}
+		return changes[i].field < changes[j].field
+	})
+
+	for _, c := range changes {
+		name := keys.Name(c.key, s.Prefix())
+		if c.old != "" {
+			fmt.Fprintf(out, "- %s %s %s\n", name, c.field, c.old)
+		}
+		if c.new != "" {
+			fmt.Fprintf(out, "+ %s %s %s\n", name, c.field, c.new)
+		}
+	}
+	if dryRun || len(changes) == 0 {
+		return nil
+	}
+
+	_, err = s.Client().TxPipelined(ctx, func(pipe redisV8.Pipeliner) error {
+		for _, c := range changes {
+			if c.new == "" {
+				pipe.HDel(ctx, c.key, c.field)
+			} else {
+				pipe.HSet(ctx, c.key, c.field, c.new)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// exportZone writes zone to out as a zone file.
+func exportZone(ctx context.Context, s *store.Store, zone string, out io.Writer) error {
+	zone = dns.Fqdn(zone)
+
+	names, err := s.ReadZone(ctx, zone)
+	if err != nil {
+		return err
+	}
+
+	sorted := make([]string, 0, len(names))
+	for key := range names {
+		sorted = append(sorted, key)
+	}
+	// The key of the apex sorts first, and children after their parent.
+	sort.Strings(sorted)
+
+	fmt.Fprintf(out, "$ORIGIN %s\n", zone)
+	for _, key := range sorted {
+		name := keys.Name(key, s.Prefix())
+		fields := make([]string, 0, len(names[key]))
+		for field := range names[key] {
+			fields = append(fields, field)
+		}
+		sort.Slice(fields, func(i, j int) bool {
+			if fields[i] == "SOA" || fields[j] == "SOA" {
+				return fields[i] == "SOA"
+			}
+			return fields[i] < fields[j]
+		})
+
+		for _, field := range fields {
+			if _, ok := dns.StringToType[field]; !ok {
+				continue
+			}
+			rrs, err := store.DecodeValue(field, names[key][field], name, zone)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "skipping %s %s: %s\n", name, field, err)
+				continue
+			}
+			for _, rr := range rrs {
+				fmt.Fprintln(out, strings.Replace(rr.String(), "\t", " ", -1))
+			}
+		}
+	}
+	return nil
+}
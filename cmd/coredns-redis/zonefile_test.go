@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	redisV8 "github.com/go-redis/redis/v8"
+	"github.com/kexirong/coredns-redis/store"
+)
+
+const testZone = `$ORIGIN example.net.
+@ 30 IN SOA ns1 hostmaster 2024010101 7200 1800 86400 30
+@ 3600 IN NS ns1
+@ 300 IN MX 10 mail
+ns1 300 IN A 192.0.2.53
+www 30 IN A 192.0.2.1
+www 30 IN A 192.0.2.2
+www 30 IN TXT "hello world"
+www 3600 IN SSHFP 4 2 123456789abcdef67890123456789abcdef67890123456789abcdef123456789
+ftp 300 IN CNAME www
+`
+
+func testStore(t *testing.T) (*store.Store, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	client := redisV8.NewUniversalClient(&redisV8.UniversalOptions{Addrs: []string{mr.Addr()}})
+	t.Cleanup(func() { client.Close() })
+	s, err := store.New(client, "coredns", store.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, mr
+}
+
+func writeZone(t *testing.T, text string) string {
+	file := filepath.Join(t.TempDir(), "example.net.zone")
+	if err := os.WriteFile(file, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestZoneFileRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	s, mr := testStore(t)
+
+	var diff bytes.Buffer
+	if err := importZone(ctx, s, "example.net", writeZone(t, testZone), false, true, &diff); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count("\n"+diff.String(), "\n+ ") != 8 || len(mr.Keys()) != 0 {
+		t.Errorf("expected a dry run of 8 additions, got %d keys and\n%s", len(mr.Keys()), diff.String())
+	}
+
+	if err := importZone(ctx, s, "example.net", writeZone(t, testZone), false, false, &diff); err != nil {
+		t.Fatal(err)
+	}
+	if val := mr.HGet("coredns:net:example:www", "A"); val != `[{"ttl":30,"ip":"192.0.2.1"},{"ttl":30,"ip":"192.0.2.2"}]` {
+		t.Errorf("expected the JSON items of www A, got %s", val)
+	}
+	if val := mr.HGet("coredns:net:example:www", "SSHFP"); store.IsJSON(val) || !strings.Contains(val, "SSHFP\t4 2 ") {
+		t.Errorf("expected zone file text for www SSHFP, got %s", val)
+	}
+
+	var exported bytes.Buffer
+	if err := exportZone(ctx, s, "example.net", &exported); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(exported.String(), "$ORIGIN example.net.\nexample.net. 30 IN SOA ") {
+		t.Errorf("expected the SOA first, got\n%s", exported.String())
+	}
+
+	// The export imports into an empty store as the same hashes, and into
+	// the same store without changes.
+	s2, mr2 := testStore(t)
+	if err := importZone(ctx, s2, "example.net", writeZone(t, exported.String()), false, false, &diff); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range mr.Keys() {
+		fields, _ := mr.HKeys(key)
+		for _, field := range fields {
+			if mr.HGet(key, field) != mr2.HGet(key, field) {
+				t.Errorf("%s %s: expected %s, got %s", key, field, mr.HGet(key, field), mr2.HGet(key, field))
+			}
+		}
+	}
+	diff.Reset()
+	if err := importZone(ctx, s, "example.net", writeZone(t, exported.String()), false, false, &diff); err != nil || diff.Len() != 0 {
+		t.Errorf("expected no changes, got %v and\n%s", err, diff.String())
+	}
+
+	// With replace, the names and types missing from the file go away.
+	if err := importZone(ctx, s, "example.net", writeZone(t, "$ORIGIN example.net.\nwww 30 IN A 192.0.2.1\n"), true, false, &diff); err != nil {
+		t.Fatal(err)
+	}
+	if fields, _ := mr.HKeys("coredns:net:example:www"); len(mr.Keys()) != 1 || len(fields) != 1 {
+		t.Errorf("expected www A only, got %v and %v", mr.Keys(), fields)
+	}
+
+	if err := importZone(ctx, s, "example.net", writeZone(t, "www.example.org. 30 IN A 192.0.2.1\n"), false, false, &diff); err == nil {
+		t.Error("expected an error for a name outside of the zone")
+	}
+}
@@ -0,0 +1,226 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/request"
+	redisV8 "github.com/go-redis/redis/v8"
+	"github.com/kexirong/coredns-redis/store"
+	"github.com/miekg/dns"
+)
+
+const (
+	failoverPrimary   = "primary"
+	failoverSecondary = "secondary"
+)
+
+type lookupFunc func(ctx context.Context, zone string, state request.Request, previousRecords []dns.RR) ([]dns.RR, bool, error)
+
+// failoverKey is the hash holding the failover state of key, operators may
+// set its "override" field to pin either set.
+func failoverKey(prefix, key string) string {
+	return store.InternalKey(prefix, "failover", strings.TrimPrefix(key, prefix+":"))
+}
+
+func (r Redis) failover(ctx context.Context, zone string, state request.Request, key string, previousRecords []dns.RR, lookup lookupFunc) ([]dns.RR, bool, error) {
+	val, err := r.get(ctx, key, store.FieldFailover)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var item ItemFailover
+	err = unmarshal(ctx, store.FieldFailover, val, &item)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if r.health == nil {
+		return nil, false, plugin.Error("redis", fmt.Errorf("%s of %s needs health_check", store.FieldFailover, state.Name()))
+	}
+
+	want := failoverSecondary
+	for _, ip := range activeIPs(item.Primary) {
+		if r.ipUp(ip) {
+			want = failoverPrimary
+			break
+		}
+	}
+
+	set := item.Primary
+	if r.health.failover(key, want, time.Duration(item.Hysteresis)*time.Second) == failoverSecondary {
+		if item.CNAME != nil {
+			return r.failoverCNAME(ctx, zone, state, *item.CNAME, previousRecords, lookup)
+		}
+		set = item.Secondary
+	}
+
+	var records []dns.RR
+	for _, ip := range r.healthyIPs(familyIPs(set, state.QType())) {
+		if state.QType() == dns.TypeAAAA {
+			records = append(records, ip.NewAAAA(state.QName()))
+		} else {
+			records = append(records, ip.NewA(state.QName()))
+		}
+	}
+	return records, false, nil
+}
+
+// failoverState is the state of a failover name. It is shared by all
+// instances through the fields of its failover hash, and read and written
+// every health check interval.
+type failoverState struct {
+	active       string
+	since        int64
+	pending      string
+	pendingSince int64
+	override     string
+
+	// want is the set the health of the name calls for, as last seen by a
+	// query of this instance.
+	want       string
+	hysteresis time.Duration
+	seen       time.Time
+}
+
+// failover returns the active set of key, and records want, the set its
+// health calls for, to switch to once it has not changed for hysteresis.
+func (h *healthChecker) failover(key, want string, hysteresis time.Duration) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.failovers[key]
+	if !ok {
+		s = &failoverState{active: failoverPrimary}
+		h.failovers[key] = s
+	}
+	s.want, s.hysteresis, s.seen = want, hysteresis, time.Now()
+	if s.override != "" {
+		return s.override
+	}
+	return s.active
+}
+
+// syncFailovers reads the shared state of the failover names seen lately,
+// moves it on towards the set their health calls for and writes the
+// transitions back.
+func (h *healthChecker) syncFailovers(ctx context.Context) {
+	h.mu.Lock()
+	keys := make([]string, 0, len(h.failovers))
+	for key, s := range h.failovers {
+		if time.Since(s.seen) > 10*h.interval {
+			delete(h.failovers, key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	h.mu.Unlock()
+
+	if len(keys) == 0 {
+		return
+	}
+	cmds := make([]*redisV8.StringStringMapCmd, len(keys))
+	_, err := h.client.Pipelined(ctx, func(pipe redisV8.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.HGetAll(ctx, failoverKey(h.prefix, key))
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// The writes are queued under the lock and sent after it.
+	now := time.Now()
+	pipe := h.client.Pipeline()
+	h.mu.Lock()
+	for i, key := range keys {
+		s, ok := h.failovers[key]
+		if !ok {
+			continue
+		}
+		s.read(cmds[i].Val())
+		if s.override != "" {
+			continue
+		}
+
+		stateKey := failoverKey(h.prefix, key)
+		switch {
+		case s.want == s.active:
+			if s.pending != "" {
+				s.pending = ""
+				pipe.HDel(ctx, stateKey, "pending", "pending_since")
+			}
+			continue
+		case s.pending != s.want:
+			s.pending, s.pendingSince = s.want, now.Unix()
+			pipe.HSet(ctx, stateKey, "pending", s.pending, "pending_since", s.pendingSince)
+		}
+		if now.Sub(time.Unix(s.pendingSince, 0)) >= s.hysteresis {
+			s.active, s.since, s.pending = s.want, now.Unix(), ""
+			pipe.HSet(ctx, stateKey, "active", s.active, "since", s.since)
+			pipe.HDel(ctx, stateKey, "pending", "pending_since")
+		}
+	}
+	h.mu.Unlock()
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Warningf("failover: %s", err)
+	}
+}
+
+// read sets the shared state from the fields of the failover hash.
+func (s *failoverState) read(fields map[string]string) {
+	s.active = fields["active"]
+	if s.active != failoverSecondary {
+		s.active = failoverPrimary
+	}
+	s.since, _ = strconv.ParseInt(fields["since"], 10, 64)
+	s.pending = fields["pending"]
+	s.pendingSince, _ = strconv.ParseInt(fields["pending_since"], 10, 64)
+	switch o := fields["override"]; o {
+	case failoverPrimary, failoverSecondary:
+		s.override = o
+	default:
+		s.override = ""
+	}
+}
+
+func (r Redis) failoverCNAME(ctx context.Context, zone string, state request.Request, item ItemHost, previousRecords []dns.RR, lookup lookupFunc) ([]dns.RR, bool, error) {
+	cname := item.NewCNAME(state.QName())
+	records := []dns.RR{cname}
+	if len(previousRecords) > 7 {
+		return records, false, nil
+	}
+
+	if zone == "." || dns.IsSubDomain(zone, cname.Target) {
+		stateNew := state.NewWithQuestion(cname.Target, state.QType())
+		next, tc, err := lookup(ctx, zone, stateNew, append(previousRecords, cname))
+		if err == nil {
+			return append(records, next...), tc, nil
+		}
+		if err != errKeyNotFound && zone != "." {
+			return records, false, nil
+		}
+	}
+
+	m, err := r.Lookup(ctx, state, cname.Target)
+	if err != nil {
+		return records, false, nil
+	}
+	return append(records, m.Answer...), m.Truncated, nil
+}
+
+func familyIPs(items []ItemIP, qtype uint16) []ItemIP {
+	var ret []ItemIP
+	for _, item := range items {
+		if item.Active() && (item.IP.To4() != nil) == (qtype == dns.TypeA) {
+			ret = append(ret, item)
+		}
+	}
+	return ret
+}
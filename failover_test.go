@@ -0,0 +1,115 @@
+package redis
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestFailover(t *testing.T) {
+	r, mr := testRedis(t)
+	key := Key("www.example.net.", "coredns")
+	stateKey := failoverKey("coredns", key)
+	mr.HSet(key, "FAILOVER", `{"primary":[{"ip":"192.0.2.1","check":{"type":"tcp","port":443}}],"secondary":[{"ip":"192.0.2.2"}]}`)
+	state := testState("www.example.net.", dns.TypeA)
+
+	answer := func(r *Redis) string {
+		records, _, err := r.A(ctx, "example.net.", state, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 1 {
+			t.Fatalf("expected 1 record, got %v", records)
+		}
+		return records[0].(*dns.A).A.String()
+	}
+
+	if _, _, err := r.A(ctx, "example.net.", state, nil); err == nil {
+		t.Error("FAILOVER answered without health_check")
+	}
+
+	r.health = newHealthChecker(r.Client, "coredns", time.Minute)
+	if ip := answer(r); ip != "192.0.2.1" {
+		t.Errorf("expected the primary, got %s", ip)
+	}
+	r.health.targets["tcp:192.0.2.1:443"].healthy = false
+	if ip := answer(r); ip != "192.0.2.1" {
+		t.Errorf("switched to %s before the health check tick", ip)
+	}
+	if mr.Exists(stateKey) {
+		t.Error("a query wrote the failover state")
+	}
+
+	// The tick switches, without hysteresis, and shares the transition.
+	r.health.syncFailovers(ctx)
+	if ip := answer(r); ip != "192.0.2.2" {
+		t.Errorf("expected the secondary, got %s", ip)
+	}
+	if mr.HGet(stateKey, "active") != failoverSecondary || mr.HGet(stateKey, "since") == "" || mr.HGet(stateKey, "pending") != "" {
+		t.Errorf("expected the transition in %s, got active %q since %q pending %q", stateKey,
+			mr.HGet(stateKey, "active"), mr.HGet(stateKey, "since"), mr.HGet(stateKey, "pending"))
+	}
+
+	// Another instance, agreeing on the health, keeps the shared state.
+	other := *r
+	other.health = newHealthChecker(r.Client, "coredns", time.Minute)
+	answer(&other)
+	other.health.targets["tcp:192.0.2.1:443"].healthy = false
+	answer(&other)
+	other.health.syncFailovers(ctx)
+	if ip := answer(&other); ip != "192.0.2.2" {
+		t.Errorf("expected the shared secondary, got %s", ip)
+	}
+
+	mr.HSet(stateKey, "override", failoverPrimary)
+	r.health.syncFailovers(ctx)
+	if ip := answer(r); ip != "192.0.2.1" {
+		t.Errorf("expected the overridden primary, got %s", ip)
+	}
+	if mr.HGet(stateKey, "active") != failoverSecondary {
+		t.Error("the override changed the active set")
+	}
+}
+
+func TestFailoverHysteresis(t *testing.T) {
+	r, mr := testRedis(t)
+	h := newHealthChecker(r.Client, "coredns", time.Minute)
+	stateKey := failoverKey("coredns", "k")
+
+	h.failover("k", failoverSecondary, time.Minute)
+	h.syncFailovers(ctx)
+	if set := h.failover("k", failoverSecondary, time.Minute); set != failoverPrimary {
+		t.Errorf("switched to %s before the hysteresis", set)
+	}
+	if mr.HGet(stateKey, "pending") != failoverSecondary || mr.HGet(stateKey, "pending_since") == "" {
+		t.Errorf("expected the pending secondary in %s", stateKey)
+	}
+
+	mr.HSet(stateKey, "pending_since", strconv.FormatInt(time.Now().Add(-2*time.Minute).Unix(), 10))
+	h.syncFailovers(ctx)
+	if set := h.failover("k", failoverSecondary, time.Minute); set != failoverSecondary {
+		t.Errorf("still %s after the hysteresis", set)
+	}
+
+	// Another instance applies the shared transition without waiting.
+	other := newHealthChecker(r.Client, "coredns", time.Minute)
+	other.failover("k", failoverSecondary, time.Minute)
+	other.syncFailovers(ctx)
+	if set := other.failover("k", failoverSecondary, time.Minute); set != failoverSecondary {
+		t.Errorf("expected the shared secondary, got %s", set)
+	}
+
+	// Flapping back restarts the wait.
+	h.failover("k", failoverPrimary, time.Minute)
+	h.syncFailovers(ctx)
+	if set := h.failover("k", failoverPrimary, time.Minute); set != failoverSecondary {
+		t.Errorf("switched back to %s before the hysteresis", set)
+	}
+	h.failover("k", failoverSecondary, time.Minute)
+	h.syncFailovers(ctx)
+	if mr.HGet(stateKey, "pending") != "" {
+		t.Error("expected the pending primary dropped")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
 	"github.com/coredns/coredns/request"
 	"github.com/miekg/dns"
 )
@@ -12,10 +13,23 @@ import (
 func (redis Redis) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
 	state := request.Request{W: w, Req: r}
 
+	if b := redis.route(state.Name()); b != nil {
+		return b.ServeDNS(ctx, w, r)
+	}
+
 	zone := plugin.Zones(redis.Zones).Matches(state.Name())
 	if zone == "" {
 		return plugin.NextOrFailure(redis.Name(), redis.Next, ctx, w, r)
 	}
+	queryCount.WithLabelValues(metrics.WithServer(ctx), zone, qtypeLabel(state.QType())).Inc()
+	if redis.queryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, redis.queryTimeout)
+		defer cancel()
+	}
+	if redis.stale != nil {
+		ctx = withStaleFlag(ctx)
+	}
 
 	var (
 		records, extra []dns.RR
@@ -44,20 +58,33 @@ func (redis Redis) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Ms
 		records, err = redis.SOA(ctx, zone, state)
 	case dns.TypeCAA:
 		records, err = redis.CAA(ctx, zone, state)
-
-	default:
+	case dns.TypeANY, dns.TypeAXFR, dns.TypeIXFR:
+		countResponse(ctx, zone, state.QType(), dns.RcodeNotImplemented, nil)
 		return redis.errorANSWER(ctx, state.QName(), dns.RcodeNotImplemented, state, nil)
+	default:
+		records, err = redis.Other(ctx, zone, state)
 	}
 
-	if err != nil {
-		if err == errKeyNotFound && redis.Fall.Through(state.Name()) {
+	if err == errKeyNotFound {
+		if redis.Fall.Through(state.Name()) {
 			return plugin.NextOrFailure(redis.Name(), redis.Next, ctx, w, r)
 		}
+		// Without records of the type, whatever the type: NODATA if the name
+		// has others, NXDOMAIN if not.
+		if err = redis.nameExists(ctx, state.Name()); err == errKeyNotFound {
+			countResponse(ctx, zone, state.QType(), dns.RcodeNameError, nil)
+			return redis.errorANSWER(ctx, state.QName(), dns.RcodeNameError, state, nil)
+		}
+	}
+
+	if err != nil {
 		// Make err nil when returning here, so we don't log spam for NXDOMAIN.
+		countResponse(ctx, zone, state.QType(), dns.RcodeServerFailure, nil)
 		return redis.errorANSWER(ctx, state.QName(), dns.RcodeServerFailure, state, nil)
 	}
 
 	if len(records) == 0 {
+		countResponse(ctx, zone, state.QType(), dns.RcodeSuccess, nil)
 		return redis.errorANSWER(ctx, state.QName(), dns.RcodeSuccess, state, nil)
 	}
 
@@ -68,8 +95,13 @@ func (redis Redis) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Ms
 	m.Answer = append(m.Answer, records...)
 
 	m.Extra = append(m.Extra, extra...)
+	if isStale(ctx) {
+		staleTTLs(m.Answer)
+	}
 
 	w.WriteMsg(m)
+	countResponse(ctx, zone, state.QType(), dns.RcodeSuccess, m.Answer)
+	traceAnswer(ctx, state.QType(), m.Answer)
 	return dns.RcodeSuccess, nil
 }
 
@@ -81,8 +113,11 @@ func (r Redis) errorANSWER(ctx context.Context, zone string, rcode int, state re
 	m := new(dns.Msg)
 	m.SetRcode(state.Req, rcode)
 	m.Authoritative = true
-	stateNew := state.NewWithQuestion(state.Name(), dns.TypeSOA)
+	stateNew := state.NewWithQuestion(state.QName(), dns.TypeSOA)
 	m.Ns, _ = r.SOA(ctx, zone, stateNew)
+	if isStale(ctx) {
+		staleTTLs(m.Ns)
+	}
 	state.W.WriteMsg(m)
 	// Return success as the rcode to signal we have written to the client.
 	return dns.RcodeSuccess, err
@@ -0,0 +1,39 @@
+package redis
+
+import (
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+func TestServeDNSNotFound(t *testing.T) {
+	r, mr := testRedis(t)
+	mr.HSet(Key("www.example.net.", "coredns"), "TXT", `[{"ttl":30,"text":"hello"}]`)
+
+	// Missing records are answered alike whatever the type.
+	tests := []struct {
+		name  string
+		qtype uint16
+		rcode int
+	}{
+		{"www.example.net.", dns.TypeA, dns.RcodeSuccess},
+		{"www.example.net.", dns.TypeMX, dns.RcodeSuccess},
+		{"www.example.net.", dns.TypeSSHFP, dns.RcodeSuccess},
+		{"nx.example.net.", dns.TypeA, dns.RcodeNameError},
+		{"nx.example.net.", dns.TypeMX, dns.RcodeNameError},
+		{"nx.example.net.", dns.TypeSSHFP, dns.RcodeNameError},
+	}
+	for _, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.name, tc.qtype)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := r.ServeDNS(ctx, rec, m); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Msg.Rcode != tc.rcode || len(rec.Msg.Answer) != 0 {
+			t.Errorf("%s %s: expected rcode %d and no answer, got %d and %v", tc.name, dns.TypeToString[tc.qtype], tc.rcode, rec.Msg.Rcode, rec.Msg.Answer)
+		}
+	}
+}
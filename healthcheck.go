@@ -0,0 +1,290 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	redisV8 "github.com/go-redis/redis/v8"
+	"github.com/kexirong/coredns-redis/store"
+)
+
+const (
+	checkTCP  = "tcp"
+	checkHTTP = "http"
+
+	defaultHealthInterval = 10 * time.Second
+	defaultCheckTimeout   = 2 * time.Second
+
+	healthUp   = "up"
+	healthDown = "down"
+)
+
+func checkTimeout(c ItemCheck) time.Duration {
+	if c.Timeout == 0 {
+		return defaultCheckTimeout
+	}
+	return time.Duration(c.Timeout) * time.Second
+}
+
+// checkPort returns the port to probe, def being the port of the record itself.
+func checkPort(c ItemCheck, def uint16) uint16 {
+	switch {
+	case c.Port != 0:
+		return c.Port
+	case def != 0:
+		return def
+	case c.Type == checkHTTP:
+		return 80
+	}
+	return 0
+}
+
+// lockTTL is the TTL of a lock taken every interval. It has to be gone by the
+// next tick of its holder, while holding off the others until then.
+func lockTTL(interval time.Duration) time.Duration {
+	return interval - interval/10
+}
+
+func probe(ctx context.Context, c ItemCheck, addr string) bool {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout(c))
+	defer cancel()
+
+	switch c.Type {
+	case checkHTTP:
+		path := c.Path
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+path, nil)
+		if err != nil {
+			return false
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode >= 200 && resp.StatusCode < 400
+
+	default:
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+}
+
+type healthTarget struct {
+	check   ItemCheck
+	addr    string
+	healthy bool
+	seen    time.Time
+}
+
+// healthChecker probes the targets seen in answers in the background. Probe
+// results are shared through redis, and a short lived lock key makes sure only
+// one instance of a fleet probes a given target per interval.
+type healthChecker struct {
+	client   redisV8.UniversalClient
+	prefix   string
+	interval time.Duration
+	owner    string
+
+	mu        sync.RWMutex
+	targets   map[string]*healthTarget
+	failovers map[string]*failoverState
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newHealthChecker(client redisV8.UniversalClient, prefix string, interval time.Duration) *healthChecker {
+	owner, _ := os.Hostname()
+	return &healthChecker{
+		client:    client,
+		prefix:    prefix,
+		interval:  interval,
+		owner:     owner + ":" + strconv.Itoa(os.Getpid()),
+		targets:   make(map[string]*healthTarget),
+		failovers: make(map[string]*failoverState),
+	}
+}
+
+func (h *healthChecker) start() error {
+	h.stop = make(chan struct{})
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		ticker := time.NewTicker(h.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-h.stop:
+				return
+			case <-ticker.C:
+				h.run()
+			}
+		}
+	}()
+	return nil
+}
+
+func (h *healthChecker) shutdown() error {
+	if h.stop != nil {
+		close(h.stop)
+		h.wg.Wait()
+		h.stop = nil
+	}
+	return nil
+}
+
+func (h *healthChecker) key(id string) string {
+	return store.InternalKey(h.prefix, "health", id)
+}
+
+// healthy reports the last known state of addr, registering it for checks
+// when it is seen for the first time. Unknown targets are considered healthy.
+func (h *healthChecker) healthy(check ItemCheck, addr string) bool {
+	id := fmt.Sprintf("%s:%s%s", check.Type, addr, check.Path)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t, ok := h.targets[id]
+	if !ok {
+		t = &healthTarget{check: check, addr: addr, healthy: true}
+		h.targets[id] = t
+	}
+	t.seen = time.Now()
+	return t.healthy
+}
+
+func (h *healthChecker) run() {
+	ctx, cancel := context.WithTimeout(context.Background(), h.interval)
+	defer cancel()
+
+	h.mu.Lock()
+	ids := make([]string, 0, len(h.targets))
+	for id, t := range h.targets {
+		// Forget targets that no longer show up in answers.
+		if time.Since(t.seen) > 10*h.interval {
+			delete(h.targets, id)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	h.mu.Unlock()
+
+	// Last, with the health states read.
+	defer h.syncFailovers(ctx)
+	if len(ids) == 0 {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		h.mu.RLock()
+		t := h.targets[id]
+		h.mu.RUnlock()
+		if t == nil {
+			continue
+		}
+
+		locked, err := h.client.SetNX(ctx, h.key(id)+":lock", h.owner, lockTTL(h.interval)).Result()
+		if err != nil || !locked {
+			continue
+		}
+
+		wg.Add(1)
+		go func(id string, check ItemCheck, addr string) {
+			defer wg.Done()
+			state := healthDown
+			if probe(ctx, check, addr) {
+				state = healthUp
+			}
+			h.client.Set(ctx, h.key(id), state, 3*h.interval)
+		}(id, t.check, t.addr)
+	}
+	wg.Wait()
+
+	cmds := make([]*redisV8.StringCmd, len(ids))
+	_, err := h.client.Pipelined(ctx, func(pipe redisV8.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.Get(ctx, h.key(id))
+		}
+		return nil
+	})
+	if err != nil && err != redisV8.Nil {
+		return
+	}
+
+	h.mu.Lock()
+	for i, id := range ids {
+		t, ok := h.targets[id]
+		if !ok {
+			continue
+		}
+		// Missing results (expired, or not yet probed) count as healthy.
+		t.healthy = cmds[i].Val() != healthDown
+	}
+	h.mu.Unlock()
+}
+
+// healthyIPs drops the items whose check failed. It never returns an empty
+// set: when every checked item is down all items are returned.
+func (r Redis) healthyIPs(items []ItemIP) []ItemIP {
+	if r.health == nil {
+		return items
+	}
+	var ret []ItemIP
+	for _, item := range items {
+		if r.ipUp(item) {
+			ret = append(ret, item)
+		}
+	}
+	if len(ret) == 0 {
+		return items
+	}
+	return ret
+}
+
+func (r Redis) ipUp(item ItemIP) bool {
+	if r.health == nil || item.Check == nil {
+		return true
+	}
+	// A tcp check without port can't pass, validation rejects it.
+	p := checkPort(*item.Check, 0)
+	return p != 0 && r.health.healthy(*item.Check, net.JoinHostPort(item.IP.String(), strconv.Itoa(int(p))))
+}
+
+// healthySRV is like healthyIPs for SRV items, probing Target:Port.
+func (r Redis) healthySRV(items []ItemSRV) []ItemSRV {
+	if r.health == nil {
+		return items
+	}
+	var ret []ItemSRV
+	for _, item := range items {
+		if item.Check == nil {
+			ret = append(ret, item)
+			continue
+		}
+		p := checkPort(*item.Check, item.Port)
+		if p != 0 && r.health.healthy(*item.Check, net.JoinHostPort(strings.TrimSuffix(item.Target, "."), strconv.Itoa(int(p)))) {
+			ret = append(ret, item)
+		}
+	}
+	if len(ret) == 0 {
+		return items
+	}
+	return ret
+}
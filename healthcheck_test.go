@@ -0,0 +1,132 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redisV8 "github.com/go-redis/redis/v8"
+	"github.com/miekg/dns"
+)
+
+// closedAddr returns an address nothing listens on.
+func closedAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	return ln.Addr().String()
+}
+
+func TestProbe(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	httpAddr := srv.Listener.Addr().String()
+
+	tests := []struct {
+		check ItemCheck
+		addr  string
+		up    bool
+	}{
+		{ItemCheck{Type: checkTCP}, ln.Addr().String(), true},
+		{ItemCheck{Type: checkTCP}, closedAddr(t), false},
+		{ItemCheck{Type: checkHTTP, Path: "healthz"}, httpAddr, true},
+		{ItemCheck{Type: checkHTTP, Path: "/broken"}, httpAddr, false},
+	}
+	for _, tc := range tests {
+		if up := probe(context.Background(), tc.check, tc.addr); up != tc.up {
+			t.Errorf("probe(%+v, %s) = %t, expected %t", tc.check, tc.addr, up, tc.up)
+		}
+	}
+}
+
+func TestHealthRun(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redisV8.NewClient(&redisV8.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	up, down := ln.Addr().String(), closedAddr(t)
+	check := ItemCheck{Type: checkTCP}
+
+	h := newHealthChecker(client, "coredns", time.Minute)
+	upKey := h.key(fmt.Sprintf("%s:%s", checkTCP, up))
+	h.healthy(check, up)
+	h.healthy(check, down)
+	h.run()
+
+	if state, _ := mr.Get(upKey); state != healthUp {
+		t.Errorf("expected state %q, got %q", healthUp, state)
+	}
+	if h.healthy(check, down) {
+		t.Errorf("%s is healthy", down)
+	}
+	if ttl := mr.TTL(upKey + ":lock"); ttl <= 0 || ttl >= h.interval {
+		t.Errorf("expected a lock shorter than the interval, got %s", ttl)
+	}
+
+	// Another instance leaves the target alone while the lock is held.
+	other := newHealthChecker(client, "coredns", time.Minute)
+	other.owner = "other"
+	other.healthy(check, up)
+	mr.Del(upKey)
+	other.run()
+	if mr.Exists(upKey) {
+		t.Error("probed while locked")
+	}
+
+	// And probes it on the next tick.
+	mr.FastForward(lockTTL(h.interval))
+	other.run()
+	if state, _ := mr.Get(upKey); state != healthUp {
+		t.Errorf("expected state %q after the lock expired, got %q", healthUp, state)
+	}
+}
+
+func TestIPUpWithoutPort(t *testing.T) {
+	r := Redis{health: newHealthChecker(nil, "", time.Minute)}
+	item := ItemIP{IP: net.ParseIP("192.0.2.1"), Check: &ItemCheck{Type: checkTCP}}
+	if r.ipUp(item) {
+		t.Error("tcp check without port is up")
+	}
+}
+
+func TestHealthyActiveOnly(t *testing.T) {
+	r, mr := testRedis(t)
+	r.health = newHealthChecker(r.Client, "coredns", time.Minute)
+	mr.HSet(Key("www.example.net.", "coredns"), "A",
+		`[{"ip":"192.0.2.1","not_after":"2000-01-01T00:00:00Z","check":{"type":"tcp","port":80}},{"ip":"192.0.2.2","check":{"type":"tcp","port":80}}]`)
+	state := testState("www.example.net.", dns.TypeA)
+
+	r.A(ctx, "example.net.", state, nil)
+	r.health.targets["tcp:192.0.2.2:80"].healthy = false
+
+	// The expired item being up doesn't matter, the active one is all there is.
+	records, _, err := r.A(ctx, "example.net.", state, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].(*dns.A).A.String() != "192.0.2.2" {
+		t.Errorf("expected 192.0.2.2, got %v", records)
+	}
+}
@@ -0,0 +1,180 @@
+// Package keys maps domain names to the redis keys of the redis plugin.
+//
+// A key is the (optional) prefix followed by the labels of the name in
+// reverse order, joined by ':'. Labels are canonical: lowercase, and Unicode
+// labels converted to their IDNA (punycode) form. So that every name has exactly one key and
+// every key exactly one name, the bytes of a label that could be mistaken for
+// the separator, the wildcard or an escape are percent-encoded: ':', '*',
+// '%', '.', '\' and anything outside of printable ASCII become %XX. A label
+// that is just '*' is the wildcard and is left as is.
+//
+//	www.example.net.      coredns:net:example:www
+//	*.example.net.        coredns:net:example:*
+//	a\058b.example.net.   coredns:net:example:a%3Ab
+//	a:b.example.net.      coredns:net:example:a%3Ab
+//	WWW.Example.NET.      coredns:net:example:www
+//	bücher.example.net.   coredns:net:example:xn--bcher-kva
+package keys
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/idna"
+)
+
+// Separator separates the components of a key.
+const Separator = ":"
+
+// Key returns the key of name below prefix.
+func Key(name, prefix string) string {
+	labels := dns.SplitDomainName(name)
+	parts := make([]string, 0, len(labels)+1)
+	if prefix != "" {
+		parts = append(parts, prefix)
+	}
+	for i := len(labels) - 1; i >= 0; i-- {
+		parts = append(parts, EncodeLabel(labels[i]))
+	}
+	return strings.Join(parts, Separator)
+}
+
+// Name is the reverse of Key, it returns the name of key in presentation
+// format.
+func Name(key, prefix string) string {
+	key = trimPrefix(key, prefix)
+	if key == "" {
+		return "."
+	}
+	parts := strings.Split(key, Separator)
+	labels := make([]string, len(parts))
+	for i, part := range parts {
+		labels[len(parts)-1-i] = DecodeLabel(part)
+	}
+	return strings.Join(labels, ".") + "."
+}
+
+// Legacy returns the key that was used for the name of key before labels
+// were encoded, that is with the labels in presentation format.
+func Legacy(key, prefix string) string {
+	rest := trimPrefix(key, prefix)
+	if rest == "" {
+		return key
+	}
+	parts := strings.Split(rest, Separator)
+	for i, part := range parts {
+		parts[i] = DecodeLabel(part)
+	}
+	if prefix != "" {
+		parts = append([]string{prefix}, parts...)
+	}
+	return strings.Join(parts, Separator)
+}
+
+// EncodeLabel returns the key component of a label in presentation format.
+func EncodeLabel(label string) string {
+	if label == "*" {
+		return label
+	}
+	raw := canonical(unescape(label))
+	var b strings.Builder
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		if c > ' ' && c < 0x7f && !strings.ContainsRune(":*%.\\", rune(c)) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// DecodeLabel is the reverse of EncodeLabel, it returns the label in
+// presentation format.
+func DecodeLabel(s string) string {
+	if s == "*" || !strings.Contains(s, "%") {
+		return s
+	}
+	raw := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				raw = append(raw, byte(v))
+				i += 2
+				continue
+			}
+		}
+		raw = append(raw, s[i])
+	}
+	return escape(raw)
+}
+
+func trimPrefix(key, prefix string) string {
+	if prefix == "" {
+		return key
+	}
+	if key == prefix {
+		return ""
+	}
+	return strings.TrimPrefix(key, prefix+Separator)
+}
+
+// unescape turns the \X and \DDD escapes of a presentation format label into
+// the bytes they stand for.
+func unescape(label string) []byte {
+	raw := make([]byte, 0, len(label))
+	for i := 0; i < len(label); i++ {
+		if label[i] != '\\' || i+1 == len(label) {
+			raw = append(raw, label[i])
+			continue
+		}
+		if i+3 < len(label) && isDigit(label[i+1]) && isDigit(label[i+2]) && isDigit(label[i+3]) {
+			v, _ := strconv.Atoi(label[i+1 : i+4])
+			raw = append(raw, byte(v))
+			i += 3
+			continue
+		}
+		raw = append(raw, label[i+1])
+		i++
+	}
+	return raw
+}
+
+// canonical lowercases an ASCII label, and converts a Unicode one to its
+// IDNA form.
+func canonical(raw []byte) []byte {
+	for _, c := range raw {
+		if c >= utf8.RuneSelf {
+			if utf8.Valid(raw) {
+				if ascii, err := idna.Lookup.ToASCII(string(raw)); err == nil {
+					raw = []byte(ascii)
+				}
+			}
+			break
+		}
+	}
+	return bytes.ToLower(raw)
+}
+
+// escape formats raw label bytes the way miekg/dns presents them.
+func escape(raw []byte) string {
+	var b strings.Builder
+	for _, c := range raw {
+		switch {
+		case strings.IndexByte(".;()\"@\\$ ", c) >= 0:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&b, "\\%03d", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
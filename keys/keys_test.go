@@ -0,0 +1,59 @@
+package keys
+
+import "testing"
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		name, prefix, key string
+	}{
+		{"www.example.net.", "coredns", "coredns:net:example:www"},
+		{"example.net.", "", "net:example"},
+		{".", "coredns", "coredns"},
+		{"*.example.net.", "coredns", "coredns:net:example:*"},
+		{"a\\058b.example.net.", "coredns", "coredns:net:example:a%3Ab"},
+		{"a:b.example.net.", "coredns", "coredns:net:example:a%3Ab"},
+		{"a*.example.net.", "coredns", "coredns:net:example:a%2A"},
+		{"a\\.b.example.net.", "coredns", "coredns:net:example:a%2Eb"},
+		{"100%.example.net.", "coredns", "coredns:net:example:100%25"},
+		{"a\\009b.example.net.", "", "net:example:a%09b"},
+		{"70.64/26.2.0.192.in-addr.arpa.", "", "arpa:in-addr:192:0:2:64/26:70"},
+		{"WWW.Example.NET.", "coredns", "coredns:net:example:www"},
+		{"bücher.example.net.", "coredns", "coredns:net:example:xn--bcher-kva"},
+		{"B\\195\\188cher.example.net.", "coredns", "coredns:net:example:xn--bcher-kva"},
+	}
+	for _, tc := range tests {
+		if got := Key(tc.name, tc.prefix); got != tc.key {
+			t.Errorf("Key(%q): expected %q, got %q", tc.name, tc.key, got)
+		}
+	}
+}
+
+func TestName(t *testing.T) {
+	tests := []struct {
+		key, prefix, name string
+	}{
+		{"coredns:net:example:www", "coredns", "www.example.net."},
+		{"coredns", "coredns", "."},
+		{"coredns:net:example:*", "coredns", "*.example.net."},
+		{"coredns:net:example:a%3Ab", "coredns", "a:b.example.net."},
+		{"coredns:net:example:a%2Eb", "coredns", "a\\.b.example.net."},
+		{"net:example:a%09b", "", "a\\009b.example.net."},
+	}
+	for _, tc := range tests {
+		if got := Name(tc.key, tc.prefix); got != tc.name {
+			t.Errorf("Name(%q): expected %q, got %q", tc.key, tc.name, got)
+		}
+		if got := Key(tc.name, tc.prefix); got != tc.key {
+			t.Errorf("Key(Name(%q)): got %q", tc.key, got)
+		}
+	}
+}
+
+func TestLegacy(t *testing.T) {
+	if got := Legacy("coredns:net:example:a%3Ab", "coredns"); got != "coredns:net:example:a:b" {
+		t.Errorf("expected coredns:net:example:a:b, got %q", got)
+	}
+	if got := Legacy("coredns:net:example:www", "coredns"); got != "coredns:net:example:www" {
+		t.Errorf("expected coredns:net:example:www, got %q", got)
+	}
+}
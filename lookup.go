@@ -2,11 +2,11 @@ package redis
 
 import (
 	"context"
-	"encoding/json"
 	"time"
 
 	"github.com/coredns/coredns/plugin/pkg/dnsutil"
 	"github.com/coredns/coredns/request"
+	"github.com/kexirong/coredns-redis/store"
 	"github.com/miekg/dns"
 )
 
@@ -16,22 +16,37 @@ doSearch:
 	val, err := r.get(ctx, key, state.Type())
 	switch err {
 	case nil:
+		if !store.IsJSON(val) {
+			records, err = rawRecords(val, state, zone)
+			if err != nil {
+				return nil, false, err
+			}
+			break
+		}
 		var rA RecordA
-		err = json.Unmarshal([]byte(val), &rA)
+		err = unmarshal(ctx, state.Type(), val, &rA)
 		if err != nil {
 			return nil, false, err
 		}
-		for _, item := range rA {
+		for _, item := range r.healthyIPs(activeIPs(rA)) {
 			records = append(records, item.NewA(state.QName()))
 		}
 
 	case errKeyNotFound:
+		if records, truncated, err := r.failover(ctx, zone, state, key, previousRecords, r.A); err != errKeyNotFound {
+			return records, truncated, err
+		}
+		if r.registry != nil && !IsAnyKey(key) {
+			if records := r.registeredIPs(ctx, state); len(records) > 0 {
+				return records, false, nil
+			}
+		}
 
-		rCNAME, err := r.cnameGet(ctx, key)
+		rCNAME, err := r.cnameGet(ctx, key, zone)
 
 		if err != nil {
 			if err == errKeyNotFound && !IsAnyKey(key) {
-				key = AnyKey(key)
+				key = wildcardKey(ctx, zone, key)
 				goto doSearch
 			}
 
@@ -39,6 +54,9 @@ doSearch:
 		}
 
 		for _, item := range rCNAME {
+			if !item.Active() {
+				continue
+			}
 			if len(previousRecords) > 7 {
 				break
 			}
@@ -94,27 +112,45 @@ doSearch:
 	val, err := r.get(ctx, key, state.Type())
 	switch err {
 	case nil:
+		if !store.IsJSON(val) {
+			records, err = rawRecords(val, state, zone)
+			if err != nil {
+				return nil, false, err
+			}
+			break
+		}
 		var rAAAA RecordAAAA
-		err = json.Unmarshal([]byte(val), &rAAAA)
+		err = unmarshal(ctx, state.Type(), val, &rAAAA)
 		if err != nil {
 			return nil, false, err
 		}
-		for _, item := range rAAAA {
+		for _, item := range r.healthyIPs(activeIPs(rAAAA)) {
 			records = append(records, item.NewAAAA(state.QName()))
 		}
 
 	case errKeyNotFound:
-		rCNAME, err := r.cnameGet(ctx, zone)
+		if records, truncated, err := r.failover(ctx, zone, state, key, previousRecords, r.AAAA); err != errKeyNotFound {
+			return records, truncated, err
+		}
+		if r.registry != nil && !IsAnyKey(key) {
+			if records := r.registeredIPs(ctx, state); len(records) > 0 {
+				return records, false, nil
+			}
+		}
+		rCNAME, err := r.cnameGet(ctx, key, zone)
 
 		if err != nil {
 			if err == errKeyNotFound && !IsAnyKey(key) {
-				key = AnyKey(key)
+				key = wildcardKey(ctx, zone, key)
 				goto doSearch
 			}
 			return nil, false, err
 		}
 
 		for _, item := range rCNAME {
+			if !item.Active() {
+				continue
+			}
 			if len(previousRecords) > 7 {
 				break
 			}
@@ -170,18 +206,25 @@ doSearch:
 	val, err := r.get(ctx, key, state.Type())
 	if err != nil {
 		if err == errKeyNotFound && !IsAnyKey(key) {
-			key = AnyKey(key)
+			key = wildcardKey(ctx, zone, key)
 			goto doSearch
 		}
 		return nil, err
 	}
 
-	err = json.Unmarshal([]byte(val), &rCNAME)
+	if !store.IsJSON(val) {
+		return rawRecords(val, state, zone)
+	}
+
+	err = unmarshal(ctx, state.Type(), val, &rCNAME)
 	if err != nil {
 		return nil, err
 	}
 
 	for _, item := range rCNAME {
+		if !item.Active() {
+			continue
+		}
 		records = append(records, item.NewCNAME(state.QName()))
 	}
 	return
@@ -193,26 +236,39 @@ doSearch:
 	val, err := r.get(ctx, key, state.Type())
 	switch err {
 	case nil:
+		if !store.IsJSON(val) {
+			records, err = rawRecords(val, state, zone)
+			if err != nil {
+				return nil, false, err
+			}
+			break
+		}
 		var rTXT RecordTXT
-		err = json.Unmarshal([]byte(val), &rTXT)
+		err = unmarshal(ctx, state.Type(), val, &rTXT)
 		if err != nil {
 			return nil, false, err
 		}
 		for _, item := range rTXT {
+			if !item.Active() {
+				continue
+			}
 			records = append(records, item.NewTXT(state.QName()))
 		}
 
 	case errKeyNotFound:
-		rCNAME, err := r.cnameGet(ctx, zone)
+		rCNAME, err := r.cnameGet(ctx, key, zone)
 		if err != nil {
 			if err == errKeyNotFound && !IsAnyKey(key) {
-				key = AnyKey(key)
+				key = wildcardKey(ctx, zone, key)
 				goto doSearch
 			}
 			return nil, false, err
 		}
 
 		for _, item := range rCNAME {
+			if !item.Active() {
+				continue
+			}
 
 			if len(previousRecords) > 7 {
 				break
@@ -269,27 +325,40 @@ doSearch:
 	val, err := r.get(ctx, key, state.Type())
 	switch err {
 	case nil:
+		if !store.IsJSON(val) {
+			records, err = rawRecords(val, state, zone)
+			if err != nil {
+				return nil, false, err
+			}
+			break
+		}
 		var rNS RecordNS
-		err = json.Unmarshal([]byte(val), &rNS)
+		err = unmarshal(ctx, state.Type(), val, &rNS)
 		if err != nil {
 			return nil, false, err
 		}
 		for _, item := range rNS {
+			if !item.Active() {
+				continue
+			}
 			records = append(records, item.NewNS(state.QName()))
 		}
 
 	case errKeyNotFound:
-		rCNAME, err := r.cnameGet(ctx, zone)
+		rCNAME, err := r.cnameGet(ctx, key, zone)
 
 		if err != nil {
 			if err == errKeyNotFound && !IsAnyKey(key) {
-				key = AnyKey(key)
+				key = wildcardKey(ctx, zone, key)
 				goto doSearch
 			}
 			return nil, false, err
 		}
 
 		for _, item := range rCNAME {
+			if !item.Active() {
+				continue
+			}
 			if len(previousRecords) > 7 {
 				break
 			}
@@ -339,19 +408,44 @@ doSearch:
 }
 
 func (r Redis) PTR(ctx context.Context, zone string, state request.Request) (records []dns.RR, err error) {
+	return r.ptr(ctx, zone, state, nil)
+}
+
+func (r Redis) ptr(ctx context.Context, zone string, state request.Request, previousRecords []dns.RR) (records []dns.RR, err error) {
 	key := Key(state.Name(), r.KeyPrefix)
 
 	val, err := r.get(ctx, key, state.Type())
-	if err == nil {
+	switch err {
+	case nil:
+		if !store.IsJSON(val) {
+			return rawRecords(val, state, zone)
+		}
 		var rPTR RecordPTR
-		err = json.Unmarshal([]byte(val), &rPTR)
+		err = unmarshal(ctx, state.Type(), val, &rPTR)
 		if err == nil {
 			for _, item := range rPTR {
-				records = append(records, item.NewNS(state.QName()))
+				if !item.Active() {
+					continue
+				}
+				records = append(records, item.NewPTR(state.QName()))
 			}
 		}
-	}
 
+	case errKeyNotFound:
+		records, err = r.ptrByIP(ctx, state)
+		if err != errKeyNotFound {
+			return records, err
+		}
+
+		rCNAME, err := r.cnameGet(ctx, key, zone)
+		if err == nil {
+			return r.ptrCNAME(ctx, zone, state, rCNAME, previousRecords)
+		}
+		if err == errKeyNotFound && r.autoPTR != nil {
+			return r.ptrIndex(ctx, state, r.autoPTR.key())
+		}
+		return nil, err
+	}
 	return
 }
 
@@ -361,26 +455,39 @@ doSearch:
 	val, err := r.get(ctx, key, state.Type())
 	switch err {
 	case nil:
+		if !store.IsJSON(val) {
+			records, err = rawRecords(val, state, zone)
+			if err != nil {
+				return nil, false, err
+			}
+			break
+		}
 		var rMX RecordMX
-		err = json.Unmarshal([]byte(val), &rMX)
+		err = unmarshal(ctx, state.Type(), val, &rMX)
 		if err != nil {
 			return nil, false, err
 		}
 		for _, item := range rMX {
+			if !item.Active() {
+				continue
+			}
 			records = append(records, item.NewMX(state.QName()))
 		}
 
 	case errKeyNotFound:
-		rCNAME, err := r.cnameGet(ctx, zone)
+		rCNAME, err := r.cnameGet(ctx, key, zone)
 		if err != nil {
 			if err == errKeyNotFound && !IsAnyKey(key) {
-				key = AnyKey(key)
+				key = wildcardKey(ctx, zone, key)
 				goto doSearch
 			}
 			return nil, false, err
 		}
 
 		for _, item := range rCNAME {
+			if !item.Active() {
+				continue
+			}
 
 			if len(previousRecords) > 7 {
 				break
@@ -436,26 +543,41 @@ doSearch:
 	val, err := r.get(ctx, key, state.Type())
 	switch err {
 	case nil:
+		if !store.IsJSON(val) {
+			records, err = rawRecords(val, state, zone)
+			if err != nil {
+				return nil, false, err
+			}
+			break
+		}
 		var rSRV RecordSRV
-		err = json.Unmarshal([]byte(val), &rSRV)
+		err = unmarshal(ctx, state.Type(), val, &rSRV)
 		if err != nil {
 			return nil, false, err
 		}
-		for _, item := range rSRV {
+		for _, item := range r.healthySRV(activeSRVs(rSRV)) {
 			records = append(records, item.NewSRV(state.QName()))
 		}
 
 	case errKeyNotFound:
-		rCNAME, err := r.cnameGet(ctx, zone)
+		if r.registry != nil && !IsAnyKey(key) {
+			if records := r.registeredSRV(ctx, state); len(records) > 0 {
+				return records, false, nil
+			}
+		}
+		rCNAME, err := r.cnameGet(ctx, key, zone)
 		if err != nil {
 			if err == errKeyNotFound && !IsAnyKey(key) {
-				key = AnyKey(key)
+				key = wildcardKey(ctx, zone, key)
 				goto doSearch
 			}
 			return nil, false, err
 		}
 
 		for _, item := range rCNAME {
+			if !item.Active() {
+				continue
+			}
 
 			if len(previousRecords) > 7 {
 				break
@@ -509,11 +631,17 @@ func (r Redis) CAA(ctx context.Context, zone string, state request.Request) (rec
 	key := Key(state.Name(), r.KeyPrefix)
 
 	val, err := r.get(ctx, key, state.Type())
+	if err == nil && !store.IsJSON(val) {
+		return rawRecords(val, state, zone)
+	}
 	if err == nil {
 		var rCAA RecordCAA
-		err = json.Unmarshal([]byte(val), &rCAA)
+		err = unmarshal(ctx, state.Type(), val, &rCAA)
 		if err == nil {
 			for _, item := range rCAA {
+				if !item.Active() {
+					continue
+				}
 				records = append(records, item.NewCAA(state.QName()))
 			}
 		}
@@ -525,35 +653,37 @@ func (r Redis) SOA(ctx context.Context, zone string, state request.Request) ([]d
 	key := Key(state.Name(), r.KeyPrefix)
 
 	val, err := r.get(ctx, key, state.Type())
-
-	switch err {
-	case nil:
+	if err == nil && !store.IsJSON(val) {
+		return rawRecords(val, state, zone)
+	}
+	if err == nil {
 		var rSOA RecordSOA
-		err = json.Unmarshal([]byte(val), &rSOA)
+		err = unmarshal(ctx, state.Type(), val, &rSOA)
 		if err != nil {
 			return nil, err
 		}
-		return []dns.RR{ItemSOA(rSOA).NewSOA(state.QName())}, nil
+		if ItemSOA(rSOA).Active() {
+			return []dns.RR{ItemSOA(rSOA).NewSOA(state.QName())}, nil
+		}
+		err = errKeyNotFound
+	}
 
-	case errKeyNotFound:
-		if zone != "." {
-			header := dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Ttl: r.MinTTL(state), Class: dns.ClassINET}
+	if err == errKeyNotFound && zone != "." {
+		header := dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Ttl: r.MinTTL(state), Class: dns.ClassINET}
 
-			Mbox := dnsutil.Join("hostmaster", zone)
-			Ns := dnsutil.Join("ns.dns", zone)
+		Mbox := dnsutil.Join("hostmaster", zone)
+		Ns := dnsutil.Join("ns.dns", zone)
 
-			soa := &dns.SOA{Hdr: header,
-				Mbox:    Mbox,
-				Ns:      Ns,
-				Serial:  uint32(time.Now().Unix()),
-				Refresh: 7200,
-				Retry:   1800,
-				Expire:  86400,
-				Minttl:  r.MinTTL(state),
-			}
-			return []dns.RR{soa}, nil
+		soa := &dns.SOA{Hdr: header,
+			Mbox:    Mbox,
+			Ns:      Ns,
+			Serial:  uint32(time.Now().Unix()),
+			Refresh: 7200,
+			Retry:   1800,
+			Expire:  86400,
+			Minttl:  r.MinTTL(state),
 		}
-
+		return []dns.RR{soa}, nil
 	}
 	return nil, err
 }
@@ -0,0 +1,150 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	queryCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "redis",
+		Name:      "queries_total",
+		Help:      "Counter of queries answered by the plugin, by zone and type.",
+	}, []string{"server", "zone", "type"})
+
+	rcodeCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "redis",
+		Name:      "responses_total",
+		Help:      "Counter of responses written by the plugin, by zone and rcode.",
+	}, []string{"server", "zone", "rcode"})
+
+	getDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "redis",
+		Name:      "get_duration_seconds",
+		Buckets:   plugin.TimeBuckets,
+		Help:      "Histogram of the time reads from redis took, by backend and result: hit, miss or error.",
+	}, []string{"server", "backend", "result"})
+
+	cnameDepth = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "redis",
+		Name:      "cname_depth",
+		Buckets:   prometheus.LinearBuckets(1, 1, 8),
+		Help:      "Histogram of the number of CNAMEs chased in answers.",
+	}, []string{"server", "zone"})
+
+	wildcardCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "redis",
+		Name:      "wildcard_fallbacks_total",
+		Help:      "Counter of lookups falling back to the wildcard key.",
+	}, []string{"server", "zone"})
+
+	decodeErrorCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "redis",
+		Name:      "json_decode_errors_total",
+		Help:      "Counter of values that failed to decode as JSON items, by type.",
+	}, []string{"server", "type"})
+
+	upstreamCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "redis",
+		Name:      "upstream_lookups_total",
+		Help:      "Counter of lookups sent upstream for CNAME targets out of the zones, by result: success or error.",
+	}, []string{"server", "result"})
+
+	staleCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "redis",
+		Name:      "stale_reads_total",
+		Help:      "Counter of reads answered from the snapshot while redis failed.",
+	}, []string{"server"})
+
+	mirrorLoadDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "redis",
+		Name:      "mirror_load_duration_seconds",
+		Help:      "Time the last full load of a mirrored zone took.",
+	}, []string{"zone"})
+
+	mirrorKeys = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "redis",
+		Name:      "mirror_names",
+		Help:      "Number of names of a mirrored zone held in memory.",
+	}, []string{"zone"})
+
+	mirrorStaleness = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "redis",
+		Name:      "mirror_staleness_seconds",
+		Help:      "Time since the last full load of a mirrored zone.",
+	}, []string{"zone"})
+)
+
+func registerMetrics(c *caddy.Controller) {
+	c.OnStartup(func() error {
+		metrics.MustRegister(c, queryCount, rcodeCount, getDuration, cnameDepth, wildcardCount, decodeErrorCount, upstreamCount, staleCount,
+			mirrorLoadDuration, mirrorKeys, mirrorStaleness)
+		return nil
+	})
+}
+
+// qtypeLabel bounds the values of the type label to the known types.
+func qtypeLabel(qtype uint16) string {
+	if t, ok := dns.TypeToString[qtype]; ok {
+		return t
+	}
+	return "other"
+}
+
+// countResponse counts the rcode of a response and, for answers, the CNAMEs
+// chased for them.
+func countResponse(ctx context.Context, zone string, qtype uint16, rcode int, answer []dns.RR) {
+	server := metrics.WithServer(ctx)
+	rcodeCount.WithLabelValues(server, zone, dns.RcodeToString[rcode]).Inc()
+
+	if depth := cnameHops(qtype, answer); depth > 0 {
+		cnameDepth.WithLabelValues(server, zone).Observe(float64(depth))
+	}
+}
+
+// cnameHops returns the number of CNAMEs chased for answer, none for CNAME
+// queries, which the CNAMEs answer.
+func cnameHops(qtype uint16, answer []dns.RR) int {
+	if qtype == dns.TypeCNAME {
+		return 0
+	}
+	hops := 0
+	for _, rr := range answer {
+		if rr.Header().Rrtype == dns.TypeCNAME {
+			hops++
+		}
+	}
+	return hops
+}
+
+// wildcardKey returns the wildcard key of key, counting the fallback.
+func wildcardKey(ctx context.Context, zone, key string) string {
+	wildcardCount.WithLabelValues(metrics.WithServer(ctx), zone).Inc()
+	return AnyKey(key)
+}
+
+// unmarshal decodes the JSON items of field, counting the failures.
+func unmarshal(ctx context.Context, field, val string, v interface{}) error {
+	err := json.Unmarshal([]byte(val), v)
+	if err != nil {
+		decodeErrorCount.WithLabelValues(metrics.WithServer(ctx), field).Inc()
+	}
+	return err
+}
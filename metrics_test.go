@@ -0,0 +1,37 @@
+package redis
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsLabels(t *testing.T) {
+	if got := qtypeLabel(dns.TypeA); got != "A" {
+		t.Errorf("qtypeLabel(A) = %q, want A", got)
+	}
+	if got := qtypeLabel(65000); got != "other" {
+		t.Errorf("qtypeLabel(65000) = %q, want other", got)
+	}
+
+	before := testutil.ToFloat64(decodeErrorCount.WithLabelValues("", "MX"))
+	var rMX RecordMX
+	if err := unmarshal(ctx, "MX", "[{", &rMX); err == nil {
+		t.Fatal("unmarshal of broken JSON succeeded")
+	}
+	if got := testutil.ToFloat64(decodeErrorCount.WithLabelValues("", "MX")); got != before+1 {
+		t.Errorf("json_decode_errors_total{type=MX} = %v, want %v", got, before+1)
+	}
+}
+
+func TestCNAMEHops(t *testing.T) {
+	cname, _ := dns.NewRR("www.example.net. 30 IN CNAME web.example.net.")
+	a, _ := dns.NewRR("web.example.net. 30 IN A 192.0.2.1")
+	if hops := cnameHops(dns.TypeA, []dns.RR{cname, a}); hops != 1 {
+		t.Errorf("expected 1 hop for A, got %d", hops)
+	}
+	if hops := cnameHops(dns.TypeCNAME, []dns.RR{cname}); hops != 0 {
+		t.Errorf("expected no hop for CNAME, got %d", hops)
+	}
+}
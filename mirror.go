@@ -0,0 +1,240 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kexirong/coredns-redis/keys"
+	"github.com/kexirong/coredns-redis/store"
+)
+
+const (
+	mirrorRetry = 5 * time.Second
+	mirrorTick  = 10 * time.Second
+	// mirrorReload is the interval of full loads, the only proof that a
+	// zone is in sync: notifications may be lost without a reconnection.
+	mirrorReload = 5 * time.Minute
+)
+
+// mirror keeps the zones in memory, loaded at startup and kept current from
+// keyspace notifications, to answer without reading redis. A zone is only
+// answered from memory once it is loaded.
+type mirror struct {
+	store *store.Store
+	zones []string
+
+	mu     sync.RWMutex
+	loaded map[string]*mirrorZone
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// mirrorZone holds the fields of every name of a zone by key.
+type mirrorZone struct {
+	zoneKey string
+	keys    map[string]map[string]string
+}
+
+func newMirror(s *store.Store, zones []string) *mirror {
+	return &mirror{store: s, zones: zones, loaded: make(map[string]*mirrorZone)}
+}
+
+func (m *mirror) start() error {
+	if err := m.checkNotify(); err != nil {
+		return err
+	}
+
+	var ctx context.Context
+	ctx, m.cancel = context.WithCancel(context.Background())
+	for _, zone := range m.zones {
+		m.wg.Add(1)
+		go func(zone string) {
+			defer m.wg.Done()
+			m.follow(ctx, zone)
+		}(zone)
+	}
+	return nil
+}
+
+func (m *mirror) shutdown() error {
+	if m.cancel != nil {
+		m.cancel()
+		m.wg.Wait()
+		m.cancel = nil
+	}
+	return nil
+}
+
+// checkNotify fails unless redis sends the keyspace notifications the mirror
+// follows. Where CONFIG is disabled, as by some managed services, it only
+// warns.
+func (m *mirror) checkNotify() error {
+	ctx, cancel := context.WithTimeout(context.Background(), mirrorRetry)
+	defer cancel()
+	val, err := m.store.Client().ConfigGet(ctx, "notify-keyspace-events").Result()
+	if err != nil || len(val) != 2 {
+		log.Warningf("mirror: can't check notify-keyspace-events: %v", err)
+		return nil
+	}
+	flags, _ := val[1].(string)
+	if !strings.Contains(flags, "K") || !strings.Contains(flags, "A") {
+		return fmt.Errorf("mirror needs notify-keyspace-events KA, redis has '%s'", flags)
+	}
+	return nil
+}
+
+// follow subscribes to the changes of zone, loads it and applies the changes
+// until ctx is done. When the subscription ends, changes may have been missed,
+// so the zone is loaded again, and so it is every mirrorReload.
+func (m *mirror) follow(ctx context.Context, zone string) {
+	var synced time.Time
+	ticker := time.NewTicker(mirrorTick)
+	defer ticker.Stop()
+
+	for ctx.Err() == nil {
+		watchCtx, cancel := context.WithCancel(ctx)
+		events, err := m.store.Watch(watchCtx, zone)
+		if err == nil {
+			err = m.load(ctx, zone)
+		}
+		if err != nil {
+			cancel()
+			if ctx.Err() == nil {
+				log.Warningf("mirror of %s: %s", zone, err)
+			}
+			observeStaleness(zone, synced)
+			select {
+			case <-ctx.Done():
+			case <-time.After(mirrorRetry):
+			}
+			continue
+		}
+		synced = time.Now()
+		observeStaleness(zone, synced)
+
+	apply:
+		for {
+			select {
+			case ev, ok := <-events:
+				if !ok {
+					break apply
+				}
+				// A burst of zone_hash writes reads the zone once.
+				if ev.Name == "" && !drain(events) {
+					break apply
+				}
+				if err := m.apply(ctx, ev); err != nil {
+					log.Warningf("mirror of %s: %s", zone, err)
+					break apply
+				}
+				if ev.Name == "" {
+					synced = time.Now()
+				}
+			case <-ticker.C:
+				if time.Since(synced) >= mirrorReload {
+					if err := m.load(ctx, zone); err != nil {
+						log.Warningf("mirror of %s: %s", zone, err)
+						break apply
+					}
+					synced = time.Now()
+				}
+				observeStaleness(zone, synced)
+			}
+		}
+		cancel()
+	}
+}
+
+func (m *mirror) load(ctx context.Context, zone string) error {
+	start := time.Now()
+	fields, err := m.store.ReadZone(ctx, zone)
+	if err != nil {
+		return err
+	}
+	mirrorLoadDuration.WithLabelValues(zone).Set(time.Since(start).Seconds())
+	mirrorKeys.WithLabelValues(zone).Set(float64(len(fields)))
+
+	m.mu.Lock()
+	m.loaded[zone] = &mirrorZone{zoneKey: m.store.Key(zone), keys: fields}
+	m.mu.Unlock()
+	return nil
+}
+
+// apply reads the name of ev again, or the whole zone for changes of an
+// unknown name.
+func (m *mirror) apply(ctx context.Context, ev store.Event) error {
+	if ev.Name == "" {
+		return m.load(ctx, ev.Zone)
+	}
+	key := m.store.Key(ev.Name)
+	fields, err := m.store.Fields(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	z := m.loaded[ev.Zone]
+	if z == nil {
+		return nil
+	}
+	if len(fields) == 0 {
+		delete(z.keys, key)
+	} else {
+		z.keys[key] = fields
+	}
+	mirrorKeys.WithLabelValues(ev.Zone).Set(float64(len(z.keys)))
+	return nil
+}
+
+// drain drops the events already queued, which reading the whole zone covers.
+// It returns false once events is closed.
+func drain(events <-chan store.Event) bool {
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return false
+			}
+		default:
+			return true
+		}
+	}
+}
+
+// get returns field of key, or whether key has any for an empty field, from
+// the most specific loaded zone of key. ok is false when no zone of key is
+// loaded.
+func (m *mirror) get(key, field string) (val string, found, ok bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var best *mirrorZone
+	for _, z := range m.loaded {
+		if z.zoneKey != "" && key != z.zoneKey && !strings.HasPrefix(key, z.zoneKey+keys.Separator) {
+			continue
+		}
+		if best == nil || len(z.zoneKey) > len(best.zoneKey) {
+			best = z
+		}
+	}
+	if best == nil {
+		return "", false, false
+	}
+	if field == "" {
+		return "", len(best.keys[key]) > 0, true
+	}
+	val, found = best.keys[key][field]
+	return val, found, true
+}
+
+func observeStaleness(zone string, synced time.Time) {
+	if synced.IsZero() {
+		return
+	}
+	mirrorStaleness.WithLabelValues(zone).Set(time.Since(synced).Seconds())
+}
@@ -0,0 +1,119 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"github.com/kexirong/coredns-redis/store"
+)
+
+func TestMirrorGet(t *testing.T) {
+	m := newMirror(nil, nil)
+	m.loaded["example.net."] = &mirrorZone{
+		zoneKey: Key("example.net.", "coredns"),
+		keys:    map[string]map[string]string{Key("www.example.net.", "coredns"): {"A": "a"}},
+	}
+	m.loaded["dev.example.net."] = &mirrorZone{
+		zoneKey: Key("dev.example.net.", "coredns"),
+		keys:    map[string]map[string]string{},
+	}
+
+	tests := []struct {
+		name, field string
+		val         string
+		found, ok   bool
+	}{
+		{"www.example.net.", "A", "a", true, true},
+		{"www.example.net.", "AAAA", "", false, true},
+		// The most specific zone holds it.
+		{"www.dev.example.net.", "A", "", false, true},
+		{"www.example.org.", "A", "", false, false},
+	}
+	for _, tt := range tests {
+		val, found, ok := m.get(Key(tt.name, "coredns"), tt.field)
+		if val != tt.val || found != tt.found || ok != tt.ok {
+			t.Errorf("get(%s, %s) = %q, %v, %v, want %q, %v, %v", tt.name, tt.field, val, found, ok, tt.val, tt.found, tt.ok)
+		}
+	}
+}
+
+// configNotify answers CONFIG GET notify-keyspace-events with flags.
+func configNotify(t *testing.T, mr *miniredis.Miniredis, flags string) {
+	err := mr.Server().Register("CONFIG", func(c *server.Peer, cmd string, args []string) {
+		c.WriteStrings([]string{"notify-keyspace-events", flags})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMirrorNotify(t *testing.T) {
+	for flags, ok := range map[string]bool{"AKE": true, "Kh": false, "": false} {
+		r, mr := testRedis(t)
+		configNotify(t, mr, flags)
+		if err := newMirror(r.store, r.Zones).checkNotify(); (err == nil) != ok {
+			t.Errorf("%q: expected ok %t, got %v", flags, ok, err)
+		}
+	}
+
+	// Without CONFIG, it only warns.
+	r, _ := testRedis(t)
+	if err := newMirror(r.store, r.Zones).checkNotify(); err != nil {
+		t.Errorf("expected no error without CONFIG, got %v", err)
+	}
+}
+
+func TestMirrorFollow(t *testing.T) {
+	r, mr := testRedis(t)
+	configNotify(t, mr, "AKE")
+	www, mail := Key("www.example.net.", "coredns"), Key("mail.example.net.", "coredns")
+	mr.HSet(www, "A", "a")
+
+	m := newMirror(r.store, r.Zones)
+	if err := m.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.shutdown()
+
+	// waitFor polls the mirror until it has val for field of key.
+	waitFor := func(what, key, field, val string, found bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if v, f, ok := m.get(key, field); ok && v == val && f == found {
+				return
+			}
+		}
+		t.Fatalf("%s: mirror doesn't have %s %s = %q", what, key, field, val)
+	}
+	waitFor("load", www, "A", "a", true)
+
+	mr.HSet(mail, "A", "b")
+	mr.Publish("__keyspace@0__:"+mail, "hset")
+	waitFor("apply", mail, "A", "b", true)
+	mr.Del(mail)
+	mr.Publish("__keyspace@0__:"+mail, "del")
+	waitFor("apply of a deletion", mail, "A", "", false)
+
+	// Changes while disconnected are read after the reconnection.
+	mr.Close()
+	mr.HSet(www, "A", "c")
+	if err := mr.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("reload", www, "A", "c", true)
+}
+
+func TestMirrorDrain(t *testing.T) {
+	events := make(chan store.Event, 3)
+	events <- store.Event{Zone: "example.net."}
+	events <- store.Event{Zone: "example.net.", Name: "www.example.net."}
+	if !drain(events) || len(events) != 0 {
+		t.Errorf("expected the queued events dropped, %d left", len(events))
+	}
+	close(events)
+	if drain(events) {
+		t.Error("expected false once closed")
+	}
+}
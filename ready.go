@@ -0,0 +1,104 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	redisV8 "github.com/go-redis/redis/v8"
+)
+
+const (
+	pingFail = "fail"
+	pingWarn = "warn"
+
+	defaultReadyInterval = 5 * time.Second
+	pingTimeout          = 5 * time.Second
+)
+
+// connChecker pings redis at startup and then every interval, the last
+// result being the readiness of the plugin.
+type connChecker struct {
+	client   redisV8.UniversalClient
+	mode     string
+	interval time.Duration
+	ready    int32
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newConnChecker(client redisV8.UniversalClient, mode string) *connChecker {
+	return &connChecker{client: client, mode: mode, interval: defaultReadyInterval}
+}
+
+func (p *connChecker) ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+
+	err := p.client.Ping(ctx).Err()
+	var ready int32
+	if err == nil {
+		ready = 1
+	}
+	if atomic.SwapInt32(&p.ready, ready) != ready {
+		if err != nil {
+			log.Warningf("redis unreachable, not ready: %s", err)
+		} else {
+			log.Infof("redis reachable again, ready")
+		}
+	}
+	return err
+}
+
+func (p *connChecker) ok() bool { return atomic.LoadInt32(&p.ready) == 1 }
+
+// start fails with the first ping in the fail mode, and only warns otherwise.
+func (p *connChecker) start() error {
+	// Start out ready, so that a first failure is logged.
+	atomic.StoreInt32(&p.ready, 1)
+	if err := p.ping(); err != nil && p.mode == pingFail {
+		return plugin.Error("redis", fmt.Errorf("ping: %s", err))
+	}
+
+	p.stop = make(chan struct{})
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.ping()
+			}
+		}
+	}()
+	return nil
+}
+
+func (p *connChecker) shutdown() error {
+	if p.stop != nil {
+		close(p.stop)
+		p.wg.Wait()
+		p.stop = nil
+	}
+	return nil
+}
+
+// Ready implements the ready.Readiness interface, for the backends too.
+// While there is a snapshot to answer from, the plugin stays ready without
+// redis.
+func (r *Redis) Ready() bool {
+	for _, b := range r.backends {
+		if !b.Ready() {
+			return false
+		}
+	}
+	return r.conn == nil || r.conn.ok() || r.stale != nil && r.stale.usable()
+}
@@ -0,0 +1,21 @@
+package redis
+
+import (
+	"testing"
+
+	redisV8 "github.com/go-redis/redis/v8"
+)
+
+func TestConnCheckerFail(t *testing.T) {
+	client := redisV8.NewUniversalClient(&redisV8.UniversalOptions{Addrs: []string{"127.0.0.1:1"}, MaxRetries: -1})
+	defer client.Close()
+
+	r := &Redis{Client: client, conn: newConnChecker(client, pingFail)}
+	if err := r.conn.start(); err == nil {
+		r.conn.shutdown()
+		t.Fatal("start succeeded without redis")
+	}
+	if r.Ready() {
+		t.Error("Ready() = true without redis")
+	}
+}
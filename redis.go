@@ -2,15 +2,19 @@ package redis
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
+	"time"
 
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
 	"github.com/coredns/coredns/plugin/pkg/fall"
 	"github.com/coredns/coredns/plugin/pkg/upstream"
 	"github.com/coredns/coredns/request"
 	redisV8 "github.com/go-redis/redis/v8"
+	"github.com/kexirong/coredns-redis/keys"
+	"github.com/kexirong/coredns-redis/registry"
+	"github.com/kexirong/coredns-redis/store"
 	"github.com/miekg/dns"
+	"golang.org/x/sync/singleflight"
 )
 
 type Redis struct {
@@ -20,32 +24,138 @@ type Redis struct {
 	KeyPrefix string
 	Zones     []string
 
+	// LegacyKeys also looks up the keys as built before labels were
+	// encoded, while migrating existing data.
+	LegacyKeys bool
+
 	Fall fall.F
 
 	Upstream *upstream.Upstream
+
+	// queryTimeout bounds the time spent on a query, redis reads and upstream
+	// lookups included.
+	queryTimeout time.Duration
+
+	store    *store.Store
+	conn     *connChecker
+	health   *healthChecker
+	registry *registry.Client
+	autoPTR  *autoPTR
+	api      *api
+	stale    *snapshot
+	mirror   *mirror
+	flight   *singleflight.Group
+
+	// backends serve zones of their own from other redis deployments.
+	backends []*Redis
+	backend  string
 }
 
-func (r *Redis) get(ctx context.Context, key, field string) (val string, err error) {
-	val, err = r.Client.HGet(ctx, key, field).Result()
+// get reads field of key from the mirror, from redis or, while redis fails
+// or is slower than the client response timer of the query, from the
+// snapshot. An empty field reads whether key has any. Internal keys are never
+// mirrored.
+func (r *Redis) get(ctx context.Context, key, field string) (string, error) {
+	if r.mirror != nil && !store.IsInternalKey(key, r.KeyPrefix) {
+		if val, found, ok := r.mirror.get(key, field); ok {
+			if !found {
+				return "", errKeyNotFound
+			}
+			return val, nil
+		}
+	}
+
+	readCtx := ctx
+	if deadline, ok := staleDeadline(ctx); ok && r.stale != nil && r.stale.usable() {
+		var cancel context.CancelFunc
+		readCtx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	val, err := r.redisGet(readCtx, key, field)
+	if err == nil || err == errKeyNotFound || r.stale == nil {
+		return val, err
+	}
+
+	staleVal, staleErr := r.stale.get(key, field, r.LegacyKeys)
+	if staleErr == errNoSnapshot {
+		return val, err
+	}
+	staleCount.WithLabelValues(metrics.WithServer(ctx)).Inc()
+	markStale(ctx)
+	return staleVal, staleErr
+}
 
-	// if err == redisV8.Nil {
-	// 	val, err = r.Client.HGet(ctx, AnyKey(key), field).Result()
-	// }
+// redisGet reads field of key from redis. Concurrent reads of the same field
+// share a single call, which goes on when the caller that made it gives up,
+// for at most queryTimeout.
+func (r *Redis) redisGet(ctx context.Context, key, field string) (string, error) {
+	if r.flight == nil {
+		return r.fetch(ctx, key, field)
+	}
 
-	if err == redisV8.Nil {
-		err = errKeyNotFound
+	ch := r.flight.DoChan(key+"\x00"+field, func() (interface{}, error) {
+		// No waiter waits longer than a query may take.
+		fetchCtx := context.Context(detached{ctx})
+		if r.queryTimeout > 0 {
+			var cancel context.CancelFunc
+			fetchCtx, cancel = context.WithTimeout(fetchCtx, r.queryTimeout)
+			defer cancel()
+		}
+		return r.fetch(fetchCtx, key, field)
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return "", res.Err
+		}
+		return res.Val.(string), nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (r *Redis) fetch(ctx context.Context, key, field string) (val string, err error) {
+	start := time.Now()
+	defer func() {
+		result := "hit"
+		if err == errKeyNotFound {
+			result = "miss"
+		} else if err != nil {
+			result = "error"
+		}
+		getDuration.WithLabelValues(metrics.WithServer(ctx), r.backendLabel(), result).Observe(time.Since(start).Seconds())
+	}()
+
+	val, err = r.store.Get(ctx, key, field)
+
+	if err == errKeyNotFound && r.LegacyKeys {
+		if legacy := keys.Legacy(key, r.KeyPrefix); legacy != key {
+			val, err = r.store.Get(ctx, legacy, field)
+		}
 	}
 	return
 }
 
-func (r *Redis) cnameGet(ctx context.Context, key string) (rCNAME RecordCNANE, err error) {
+func (r *Redis) cnameGet(ctx context.Context, key, zone string) (rCNAME RecordCNANE, err error) {
 
 	val, err := r.get(ctx, key, dns.Type(dns.TypeCNAME).String())
 	if err != nil {
 		return nil, err
 	}
 
-	err = json.Unmarshal([]byte(val), &rCNAME)
+	if !store.IsJSON(val) {
+		records, err := store.DecodeRaw(val, keyName(key, r.KeyPrefix), zone, dns.TypeCNAME)
+		if err != nil {
+			return nil, err
+		}
+		for _, rr := range records {
+			rCNAME = append(rCNAME, ItemHost{TTL: rr.Header().Ttl, Host: rr.(*dns.CNAME).Target})
+		}
+		return rCNAME, nil
+	}
+
+	err = unmarshal(ctx, dns.Type(dns.TypeCNAME).String(), val, &rCNAME)
 	if err != nil {
 		return nil, err
 	}
@@ -54,10 +164,34 @@ func (r *Redis) cnameGet(ctx context.Context, key string) (rCNAME RecordCNANE, e
 }
 
 func (r *Redis) Lookup(ctx context.Context, state request.Request, name string) (*dns.Msg, error) {
-	return r.Upstream.Lookup(ctx, state, name, state.QType())
+	finish := traceUpstream(ctx, name)
+	m, err := r.Upstream.Lookup(ctx, state, name, state.QType())
+	finish(err)
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	upstreamCount.WithLabelValues(metrics.WithServer(ctx), result).Inc()
+	return m, err
 }
 
-var errKeyNotFound = errors.New("key not found")
+// backendLabel is the label of the metrics of r.
+func (r *Redis) backendLabel() string {
+	if r.backend == "" {
+		return "default"
+	}
+	return r.backend
+}
+
+var errKeyNotFound = store.ErrNotFound
+
+// detached keeps the values of a context, such as the span of the trace, but
+// not its cancellation.
+type detached struct{ context.Context }
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
 
 // MinTTL returns the minimal TTL.
 func (*Redis) MinTTL(state request.Request) uint32 {
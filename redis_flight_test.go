@@ -0,0 +1,110 @@
+package redis
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	redisV8 "github.com/go-redis/redis/v8"
+	"github.com/kexirong/coredns-redis/store"
+	"golang.org/x/sync/singleflight"
+)
+
+// blockingHook counts the commands and holds them until release is closed.
+type blockingHook struct {
+	calls   *int32
+	release chan struct{}
+}
+
+func (h blockingHook) BeforeProcess(ctx context.Context, cmd redisV8.Cmder) (context.Context, error) {
+	atomic.AddInt32(h.calls, 1)
+	select {
+	case <-h.release:
+		return ctx, nil
+	case <-ctx.Done():
+		return ctx, ctx.Err()
+	}
+}
+
+func (blockingHook) AfterProcess(ctx context.Context, cmd redisV8.Cmder) error { return nil }
+
+func (blockingHook) BeforeProcessPipeline(ctx context.Context, cmds []redisV8.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (blockingHook) AfterProcessPipeline(ctx context.Context, cmds []redisV8.Cmder) error { return nil }
+
+func TestGetCoalesced(t *testing.T) {
+	client := redisV8.NewUniversalClient(&redisV8.UniversalOptions{Addrs: []string{"127.0.0.1:1"}, MaxRetries: -1})
+	defer client.Close()
+	hook := blockingHook{calls: new(int32), release: make(chan struct{})}
+	client.AddHook(hook)
+	s, err := store.New(client, "coredns", store.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Redis{Client: client, KeyPrefix: "coredns", store: s, flight: new(singleflight.Group)}
+	key := Key("www.example.net.", "coredns")
+
+	// A caller giving up doesn't wait for the call.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := r.get(ctx, key, "A"); err != context.Canceled {
+		t.Errorf("get with a canceled context: %v, want context.Canceled", err)
+	}
+
+	// The call of the canceled caller went on, wait for it to be made.
+	waitCalls(t, hook.calls, 1)
+
+	var started, wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		started.Add(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			started.Done()
+			r.get(context.Background(), key, "A")
+		}()
+	}
+	started.Wait()
+	close(hook.release)
+	wg.Wait()
+
+	if calls := atomic.LoadInt32(hook.calls); calls != 1 {
+		t.Errorf("got %d redis calls, want 1", calls)
+	}
+}
+
+func TestGetCoalescedTimeout(t *testing.T) {
+	client := redisV8.NewUniversalClient(&redisV8.UniversalOptions{Addrs: []string{"127.0.0.1:1"}, MaxRetries: -1})
+	defer client.Close()
+	hook := blockingHook{calls: new(int32), release: make(chan struct{})}
+	client.AddHook(hook)
+	s, err := store.New(client, "coredns", store.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Redis{Client: client, KeyPrefix: "coredns", store: s, flight: new(singleflight.Group), queryTimeout: 50 * time.Millisecond}
+
+	// The shared call ends with queryTimeout, also for a waiter without
+	// deadline.
+	start := time.Now()
+	if _, err := r.get(context.Background(), Key("www.example.net.", "coredns"), "A"); err != context.DeadlineExceeded {
+		t.Errorf("get of a hanging redis: %v, want context.DeadlineExceeded", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("get returned after %s, want queryTimeout", time.Since(start))
+	}
+}
+
+// waitCalls waits until the hook saw n calls.
+func waitCalls(t *testing.T, calls *int32, n int32) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt32(calls) < n; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d redis calls, want %d", atomic.LoadInt32(calls), n)
+		}
+	}
+}
@@ -4,7 +4,11 @@ import (
 	"context"
 	"testing"
 
+	"github.com/alicebob/miniredis/v2"
+	"github.com/coredns/coredns/request"
 	redisV8 "github.com/go-redis/redis/v8"
+	"github.com/kexirong/coredns-redis/store"
+	"github.com/miekg/dns"
 )
 
 var ctx = context.Background()
@@ -17,3 +21,21 @@ func TestRedisGet(t *testing.T) {
 	t.Error(ret)
 	t.Error(err)
 }
+
+// testRedis returns a plugin for example.net. reading from a miniredis.
+func testRedis(t *testing.T) (*Redis, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	client := redisV8.NewUniversalClient(&redisV8.UniversalOptions{Addrs: []string{mr.Addr()}})
+	t.Cleanup(func() { client.Close() })
+	s, err := store.New(client, "coredns", store.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Redis{Client: client, KeyPrefix: "coredns", Zones: []string{"example.net."}, store: s}, mr
+}
+
+func testState(name string, qtype uint16) request.Request {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	return request.Request{Req: m}
+}
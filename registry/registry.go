@@ -0,0 +1,211 @@
+// Package registry registers service instances for the redis plugin.
+//
+// Every instance is stored as its own key with an expiry (the lease), so an
+// instance that stops renewing its lease disappears from DNS on its own. The
+// ids of the instances of a service are kept in a set next to them:
+//
+//	PREFIX:_sd:net:example:_tcp:_http          set of instance ids
+//	PREFIX:_sd:net:example:_tcp:_http:web-1    instance web-1, as JSON
+//
+// An instance is answered as web-1._http._tcp.example.net, the service name
+// returns the SRV (and A/AAAA) records of all of its live instances.
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	redisV8 "github.com/go-redis/redis/v8"
+	"github.com/kexirong/coredns-redis/keys"
+	"github.com/miekg/dns"
+)
+
+// ErrLease is returned for leases shorter than a second, the resolution of
+// key expiries.
+var ErrLease = errors.New("lease shorter than a second")
+
+// Instance is a single endpoint of a service.
+type Instance struct {
+	ID       string `json:"id"`
+	Host     string `json:"host,omitempty"`
+	IP       net.IP `json:"ip,omitempty"`
+	Port     uint16 `json:"port"`
+	Priority uint16 `json:"priority,omitempty"`
+	Weight   uint16 `json:"weight,omitempty"`
+	TTL      uint32 `json:"ttl,omitempty"`
+}
+
+// Target returns the name SRV records of the instance point to: Host when
+// set, the instance name below service otherwise.
+func (i Instance) Target(service string) string {
+	if i.Host != "" {
+		return dns.Fqdn(i.Host)
+	}
+	return dns.Fqdn(i.ID + "." + dns.Fqdn(service))
+}
+
+// Client registers, renews and deregisters instances.
+type Client struct {
+	Redis  redisV8.UniversalClient
+	Prefix string
+}
+
+// New returns a Client storing instances below prefix, which must match the
+// key_prefix of the plugin.
+func New(client redisV8.UniversalClient, prefix string) *Client {
+	return &Client{Redis: client, Prefix: prefix}
+}
+
+// ServiceKey returns the key of the set holding the instance ids of service.
+func ServiceKey(prefix, service string) string {
+	if prefix != "" {
+		prefix += keys.Separator
+	}
+	return keys.Key(service, prefix+"_sd")
+}
+
+// InstanceKey returns the key of instance id of service.
+func InstanceKey(prefix, service, id string) string {
+	return ServiceKey(prefix, id+"."+dns.Fqdn(service))
+}
+
+// Register stores inst for service with the given lease.
+func (c *Client) Register(ctx context.Context, service string, inst Instance, lease time.Duration) error {
+	if lease < time.Second {
+		return ErrLease
+	}
+	if inst.ID == "" || strings.ContainsAny(inst.ID, ".:") {
+		return errors.New("invalid instance id")
+	}
+	val, err := json.Marshal(inst)
+	if err != nil {
+		return err
+	}
+	_, err = c.Redis.Pipelined(ctx, func(pipe redisV8.Pipeliner) error {
+		pipe.Set(ctx, InstanceKey(c.Prefix, service, inst.ID), val, lease)
+		pipe.SAdd(ctx, ServiceKey(c.Prefix, service), inst.ID)
+		return nil
+	})
+	return err
+}
+
+// Renew extends the lease of inst, registering it again when it already
+// expired.
+func (c *Client) Renew(ctx context.Context, service string, inst Instance, lease time.Duration) error {
+	return c.Register(ctx, service, inst, lease)
+}
+
+// Deregister removes instance id right away.
+func (c *Client) Deregister(ctx context.Context, service, id string) error {
+	_, err := c.Redis.Pipelined(ctx, func(pipe redisV8.Pipeliner) error {
+		pipe.Del(ctx, InstanceKey(c.Prefix, service, id))
+		pipe.SRem(ctx, ServiceKey(c.Prefix, service), id)
+		return nil
+	})
+	return err
+}
+
+// KeepAlive registers inst and renews its lease until ctx is done, after
+// which the instance is deregistered. An instance whose lease expired in
+// between, e.g. during a redis outage, is registered again.
+func (c *Client) KeepAlive(ctx context.Context, service string, inst Instance, lease time.Duration) error {
+	if err := c.Register(ctx, service, inst, lease); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			dctx, cancel := context.WithTimeout(context.Background(), lease/3)
+			defer cancel()
+			return c.Deregister(dctx, service, inst.ID)
+		case <-ticker.C:
+			// Retried on the next tick.
+			c.Renew(ctx, service, inst, lease)
+		}
+	}
+}
+
+// Instance returns the instance answering to name, an instance name such as
+// web-1._http._tcp.example.net. It returns nil if there is none.
+func (c *Client) Instance(ctx context.Context, name string) (*Instance, error) {
+	val, err := c.Redis.Get(ctx, ServiceKey(c.Prefix, name)).Result()
+	if err == redisV8.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var inst Instance
+	if err := json.Unmarshal([]byte(val), &inst); err != nil {
+		return nil, err
+	}
+	return &inst, nil
+}
+
+// Instances returns the live instances of service. Ids whose lease expired
+// are removed from the set of the service, unless registered again meanwhile.
+func (c *Client) Instances(ctx context.Context, service string) ([]Instance, error) {
+	setKey := ServiceKey(c.Prefix, service)
+	ids, err := c.Redis.SMembers(ctx, setKey).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	// A pipeline rather than MGET, the keys may live in different cluster slots.
+	cmds := make([]*redisV8.StringCmd, len(ids))
+	_, err = c.Redis.Pipelined(ctx, func(pipe redisV8.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.Get(ctx, InstanceKey(c.Prefix, service, id))
+		}
+		return nil
+	})
+	if err != nil && err != redisV8.Nil {
+		return nil, err
+	}
+
+	var (
+		instances []Instance
+		expired   []string
+	)
+	for i, cmd := range cmds {
+		s, err := cmd.Result()
+		if err == redisV8.Nil {
+			expired = append(expired, ids[i])
+			continue
+		}
+		var inst Instance
+		if err := json.Unmarshal([]byte(s), &inst); err != nil {
+			continue
+		}
+		instances = append(instances, inst)
+	}
+	for _, id := range expired {
+		c.forget(ctx, service, id)
+	}
+	return instances, nil
+}
+
+// forget removes id from the set of service if its key is still gone. On a
+// cluster, this fails when the keys are on different nodes, the id is then
+// skipped by the readers.
+func (c *Client) forget(ctx context.Context, service, id string) error {
+	instKey := InstanceKey(c.Prefix, service, id)
+	return c.Redis.Watch(ctx, func(tx *redisV8.Tx) error {
+		n, err := tx.Exists(ctx, instKey).Result()
+		if err != nil || n > 0 {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redisV8.Pipeliner) error {
+			pipe.SRem(ctx, ServiceKey(c.Prefix, service), id)
+			return nil
+		})
+		return err
+	}, instKey)
+}
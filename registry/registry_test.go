@@ -0,0 +1,113 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redisV8 "github.com/go-redis/redis/v8"
+)
+
+var ctx = context.Background()
+
+func testClient(t *testing.T) (*Client, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	client := redisV8.NewUniversalClient(&redisV8.UniversalOptions{Addrs: []string{mr.Addr()}})
+	t.Cleanup(func() { client.Close() })
+	return New(client, "coredns"), mr
+}
+
+const service = "_http._tcp.example.net."
+
+func TestLease(t *testing.T) {
+	c, _ := testClient(t)
+	inst := Instance{ID: "web-1", IP: net.ParseIP("10.0.0.1"), Port: 8080}
+	if err := c.KeepAlive(ctx, service, inst, 2); err != ErrLease {
+		t.Errorf("KeepAlive with a lease of 2ns: %v, want ErrLease", err)
+	}
+}
+
+func TestRenewExpired(t *testing.T) {
+	c, mr := testClient(t)
+	inst := Instance{ID: "web-1", IP: net.ParseIP("10.0.0.1"), Port: 8080}
+	if err := c.Register(ctx, service, inst, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	mr.FastForward(2 * time.Minute)
+	if mr.Exists(InstanceKey("coredns", service, "web-1")) {
+		t.Fatal("instance did not expire")
+	}
+
+	if err := c.Renew(ctx, service, inst, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	instances, err := c.Instances(ctx, service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 1 || instances[0].ID != "web-1" {
+		t.Errorf("expected web-1 back, got %v", instances)
+	}
+}
+
+func TestInstancesExpired(t *testing.T) {
+	c, mr := testClient(t)
+	for id, lease := range map[string]time.Duration{"web-1": time.Hour, "web-2": time.Minute} {
+		if err := c.Register(ctx, service, Instance{ID: id, IP: net.ParseIP("10.0.0.1"), Port: 8080}, lease); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mr.FastForward(2 * time.Minute)
+
+	instances, err := c.Instances(ctx, service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 1 || instances[0].ID != "web-1" {
+		t.Errorf("expected web-1 only, got %v", instances)
+	}
+	if ids, _ := mr.Members(ServiceKey("coredns", service)); len(ids) != 1 || ids[0] != "web-1" {
+		t.Errorf("expected the set to hold web-1 only, got %v", ids)
+	}
+}
+
+// registerHook registers an instance again right after the liveness check
+// of forget.
+type registerHook struct {
+	mr  *miniredis.Miniredis
+	key string
+}
+
+func (h registerHook) BeforeProcess(ctx context.Context, cmd redisV8.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h registerHook) AfterProcess(ctx context.Context, cmd redisV8.Cmder) error {
+	if cmd.Name() == "exists" {
+		h.mr.Set(h.key, `{"id":"web-1","port":8080}`)
+	}
+	return nil
+}
+
+func (registerHook) BeforeProcessPipeline(ctx context.Context, cmds []redisV8.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (registerHook) AfterProcessPipeline(ctx context.Context, cmds []redisV8.Cmder) error { return nil }
+
+func TestForgetRegisteredAgain(t *testing.T) {
+	c, mr := testClient(t)
+	inst := Instance{ID: "web-1", IP: net.ParseIP("10.0.0.1"), Port: 8080}
+	if err := c.Register(ctx, service, inst, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	mr.FastForward(2 * time.Minute)
+
+	c.Redis.AddHook(registerHook{mr: mr, key: InstanceKey("coredns", service, "web-1")})
+	c.Instances(ctx, service)
+	if ok, _ := mr.IsMember(ServiceKey("coredns", service), "web-1"); !ok {
+		t.Error("instance registered again was removed from the set")
+	}
+}
@@ -0,0 +1,139 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/coredns/coredns/plugin/pkg/dnsutil"
+	"github.com/coredns/coredns/request"
+	"github.com/kexirong/coredns-redis/store"
+	"github.com/miekg/dns"
+)
+
+// ReverseName returns the in-addr.arpa or (nibble) ip6.arpa name of ip.
+func ReverseName(ip net.IP) string {
+	name, _ := dns.ReverseAddr(ip.String())
+	return name
+}
+
+// ReverseKey returns the key of the reverse name of ip.
+func ReverseKey(ip net.IP, prefix string) string {
+	return Key(ReverseName(ip), prefix)
+}
+
+// PTRByIPKey returns the key of the hash holding PTR records by address, the
+// fields being addresses as returned by net.IP.String and the values the same
+// JSON as a PTR field. It spares storing ip6.arpa names of 34 labels.
+func PTRByIPKey(prefix string) string {
+	return store.InternalKey(prefix, "ptr")
+}
+
+// ClasslessZone returns the RFC 2317 zone of an IPv4 network smaller than a
+// /24, such as 0/26.2.0.192.in-addr.arpa. for 192.0.2.0/26.
+func ClasslessZone(network *net.IPNet) (string, error) {
+	ones, bits := network.Mask.Size()
+	ip := network.IP.To4()
+	if ip == nil || bits != 32 || ones <= 24 || ones > 32 {
+		return "", fmt.Errorf("%s is not a classless IPv4 network", network)
+	}
+	return fmt.Sprintf("%d/%d.%d.%d.%d.in-addr.arpa.", ip[3], ones, ip[2], ip[1], ip[0]), nil
+}
+
+// ClasslessName returns the name ip has in the RFC 2317 zone of network,
+// which the regular reverse name of ip is a CNAME to in the parent zone.
+func ClasslessName(ip net.IP, network *net.IPNet) (string, error) {
+	zone, err := ClasslessZone(network)
+	if err != nil {
+		return "", err
+	}
+	if !network.Contains(ip) {
+		return "", fmt.Errorf("%s is not in %s", ip, network)
+	}
+	return fmt.Sprintf("%d.%s", ip.To4()[3], zone), nil
+}
+
+// reverseAddress returns the address of a reverse name, also of one in an
+// RFC 2317 zone, or nil.
+func reverseAddress(name string) net.IP {
+	labels := dns.SplitDomainName(strings.ToLower(name))
+	if len(labels) > 2 && strings.ContainsAny(labels[1], "/-") {
+		labels = append(labels[:1], labels[2:]...)
+	}
+	return net.ParseIP(dnsutil.ExtractAddressFromReverse(dns.Fqdn(strings.Join(labels, "."))))
+}
+
+// ptrIndex answers a PTR query from index, a hash of PTR records by address
+// such as the auto_ptr one.
+func (r Redis) ptrIndex(ctx context.Context, state request.Request, index string) ([]dns.RR, error) {
+	ip := reverseAddress(state.Name())
+	if ip == nil {
+		return nil, errKeyNotFound
+	}
+
+	val, err := r.get(ctx, index, ip.String())
+	if err != nil {
+		return nil, err
+	}
+
+	if !store.IsJSON(val) {
+		return rawRecords(val, state, ".")
+	}
+
+	var rPTR RecordPTR
+	if err := unmarshal(ctx, dns.Type(dns.TypePTR).String(), val, &rPTR); err != nil {
+		return nil, err
+	}
+	var records []dns.RR
+	for _, item := range rPTR {
+		if !item.Active() {
+			continue
+		}
+		records = append(records, item.NewPTR(state.QName()))
+	}
+	return records, nil
+}
+
+// ptrByIP answers a PTR query from the records stored by address, read like
+// any other field, from the store of the backend serving the name.
+func (r Redis) ptrByIP(ctx context.Context, state request.Request) ([]dns.RR, error) {
+	return r.ptrIndex(ctx, state, PTRByIPKey(r.KeyPrefix))
+}
+
+// ptrCNAME follows the CNAMEs of a reverse name, as used by RFC 2317 to point
+// into classless zones.
+func (r Redis) ptrCNAME(ctx context.Context, zone string, state request.Request, rCNAME RecordCNANE, previousRecords []dns.RR) (records []dns.RR, err error) {
+	for _, item := range rCNAME {
+		if !item.Active() {
+			continue
+		}
+		if len(previousRecords) > 7 {
+			break
+		}
+		cnameRecode := item.NewCNAME(state.QName())
+		if dnsutil.DuplicateCNAME(cnameRecode, previousRecords) {
+			continue
+		}
+
+		if dns.IsSubDomain(zone, cnameRecode.Target) {
+			stateNew := state.NewWithQuestion(cnameRecode.Target, state.QType())
+			nextRecords, err := r.ptr(ctx, zone, stateNew, append(previousRecords, cnameRecode))
+			if err != nil && err != errKeyNotFound {
+				continue
+			}
+			// A missing target leaves the CNAME alone in the answer.
+			records = append(records, cnameRecode)
+			records = append(records, nextRecords...)
+			continue
+		}
+
+		m1, e1 := r.Lookup(ctx, state, cnameRecode.Target)
+		if e1 != nil {
+			continue
+		}
+		records = append(records, cnameRecode)
+		records = append(records, m1.Answer...)
+	}
+	return records, nil
+}
@@ -0,0 +1,117 @@
+package redis
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestClasslessName(t *testing.T) {
+	_, network, _ := net.ParseCIDR("192.0.2.64/26")
+
+	zone, err := ClasslessZone(network)
+	if err != nil || zone != "64/26.2.0.192.in-addr.arpa." {
+		t.Errorf("expected zone 64/26.2.0.192.in-addr.arpa., got %q (%v)", zone, err)
+	}
+
+	name, err := ClasslessName(net.ParseIP("192.0.2.70"), network)
+	if err != nil || name != "70.64/26.2.0.192.in-addr.arpa." {
+		t.Errorf("expected name 70.64/26.2.0.192.in-addr.arpa., got %q (%v)", name, err)
+	}
+
+	if _, err := ClasslessName(net.ParseIP("192.0.2.1"), network); err == nil {
+		t.Error("expected an error for an address outside of the network")
+	}
+
+	_, network, _ = net.ParseCIDR("192.0.2.0/24")
+	if _, err := ClasslessZone(network); err == nil {
+		t.Error("expected an error for a /24")
+	}
+}
+
+func TestClasslessEdges(t *testing.T) {
+	tests := []struct {
+		network *net.IPNet
+		zone    string
+	}{
+		{&net.IPNet{IP: net.ParseIP("192.0.2.128"), Mask: net.CIDRMask(25, 32)}, "128/25.2.0.192.in-addr.arpa."},
+		{&net.IPNet{IP: net.ParseIP("192.0.2.7"), Mask: net.CIDRMask(32, 32)}, "7/32.2.0.192.in-addr.arpa."},
+		{&net.IPNet{IP: net.ParseIP("0.0.0.0"), Mask: net.CIDRMask(0, 32)}, ""},
+		{&net.IPNet{IP: net.ParseIP("192.0.2.0"), Mask: net.CIDRMask(33, 32)}, ""},
+		{&net.IPNet{IP: net.ParseIP("192.0.2.0"), Mask: net.IPMask{255, 0, 255, 0}}, ""},
+		{&net.IPNet{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(120, 128)}, ""},
+	}
+	for _, tc := range tests {
+		zone, err := ClasslessZone(tc.network)
+		if tc.zone == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %q", tc.network, zone)
+			}
+			continue
+		}
+		if err != nil || zone != tc.zone {
+			t.Errorf("%s: expected %q, got %q (%v)", tc.network, tc.zone, zone, err)
+		}
+	}
+
+	_, network, _ := net.ParseCIDR("192.0.2.7/32")
+	if name, err := ClasslessName(net.ParseIP("192.0.2.7"), network); err != nil || name != "7.7/32.2.0.192.in-addr.arpa." {
+		t.Errorf("expected 7.7/32.2.0.192.in-addr.arpa., got %q (%v)", name, err)
+	}
+	if _, err := ClasslessName(net.ParseIP("2001:db8::1"), network); err == nil {
+		t.Error("expected an error for an IPv6 address")
+	}
+
+	for _, name := range []string{"70.64/26.2.0.192.in-addr.arpa.", "70.64-127.2.0.192.in-addr.arpa.", "70.2.0.192.in-addr.arpa."} {
+		if ip := reverseAddress(name); !ip.Equal(net.ParseIP("192.0.2.70")) {
+			t.Errorf("reverseAddress(%s) = %s", name, ip)
+		}
+	}
+}
+
+func TestPTRCNAMEMissingTarget(t *testing.T) {
+	r, mr := testRedis(t)
+	zone := "2.0.192.in-addr.arpa."
+	mr.HSet(Key("70."+zone, "coredns"), "CNAME", `[{"ttl":30,"host":"70.64/26.2.0.192.in-addr.arpa."}]`)
+
+	records, err := r.PTR(ctx, zone, testState("70."+zone, dns.TypePTR))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Header().Rrtype != dns.TypeCNAME {
+		t.Errorf("expected the CNAME alone, got %v", records)
+	}
+
+	mr.HSet(Key("70.64/26."+zone, "coredns"), "PTR", `[{"ttl":30,"host":"www.example.net."}]`)
+	records, err = r.PTR(ctx, zone, testState("70."+zone, dns.TypePTR))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1].(*dns.PTR).Ptr != "www.example.net." {
+		t.Errorf("expected the CNAME and the PTR, got %v", records)
+	}
+}
+
+func TestPTRByIP(t *testing.T) {
+	r, mr := testRedis(t)
+	zone := "2.0.192.in-addr.arpa."
+	mr.HSet(PTRByIPKey("coredns"), "192.0.2.1", `[{"ttl":30,"host":"www.example.net."},{"ttl":30,"host":"old.example.net.","not_after":"2000-01-01T00:00:00Z"}]`)
+
+	records, err := r.PTR(ctx, zone, testState("1."+zone, dns.TypePTR))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].(*dns.PTR).Ptr != "www.example.net." {
+		t.Errorf("expected www.example.net. alone, got %v", records)
+	}
+
+	// Read like any other field, the mirror of the root zone doesn't hide it.
+	r.mirror = newMirror(r.store, []string{"."})
+	if err := r.mirror.load(ctx, "."); err != nil {
+		t.Fatal(err)
+	}
+	if records, err := r.PTR(ctx, zone, testState("1."+zone, dns.TypePTR)); err != nil || len(records) != 1 {
+		t.Errorf("expected www.example.net. through the mirror, got %v (%v)", records, err)
+	}
+}
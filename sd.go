@@ -0,0 +1,69 @@
+package redis
+
+import (
+	"context"
+	"strings"
+
+	"github.com/coredns/coredns/request"
+	"github.com/kexirong/coredns-redis/registry"
+	"github.com/miekg/dns"
+)
+
+// registeredIPs answers A and AAAA queries from the registry: an instance name
+// returns the address of that instance, a service name the addresses of all
+// of its instances.
+func (r Redis) registeredIPs(ctx context.Context, state request.Request) []dns.RR {
+	instances := r.registered(ctx, state.Name())
+
+	var records []dns.RR
+	for _, inst := range instances {
+		if inst.IP == nil || (inst.IP.To4() != nil) != (state.QType() == dns.TypeA) {
+			continue
+		}
+		item := ItemIP{TTL: inst.TTL, IP: inst.IP}
+		if state.QType() == dns.TypeAAAA {
+			records = append(records, item.NewAAAA(state.QName()))
+		} else {
+			records = append(records, item.NewA(state.QName()))
+		}
+	}
+	return records
+}
+
+// registeredSRV answers SRV queries for a service name from the registry.
+func (r Redis) registeredSRV(ctx context.Context, state request.Request) []dns.RR {
+	instances, err := r.registry.Instances(ctx, state.Name())
+	if err != nil {
+		return nil
+	}
+
+	var records []dns.RR
+	for _, inst := range instances {
+		item := ItemSRV{
+			TTL:      inst.TTL,
+			Priority: inst.Priority,
+			Weight:   inst.Weight,
+			Port:     inst.Port,
+			Target:   inst.Target(state.Name()),
+		}
+		records = append(records, item.NewSRV(state.QName()))
+	}
+	return records
+}
+
+func (r Redis) registered(ctx context.Context, name string) []registry.Instance {
+	// Instance names are a single label, without the underscore of a
+	// service, below the service, which starts with one.
+	if labels := dns.SplitDomainName(name); len(labels) > 1 && !strings.HasPrefix(labels[0], "_") && strings.HasPrefix(labels[1], "_") {
+		inst, err := r.registry.Instance(ctx, name)
+		if err == nil && inst != nil {
+			return []registry.Instance{*inst}
+		}
+	}
+
+	instances, err := r.registry.Instances(ctx, name)
+	if err != nil {
+		return nil
+	}
+	return instances
+}
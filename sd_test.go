@@ -0,0 +1,30 @@
+package redis
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kexirong/coredns-redis/registry"
+)
+
+func TestRegistered(t *testing.T) {
+	r, mr := testRedis(t)
+	r.registry = registry.New(r.Client, "coredns")
+	if err := r.registry.Register(ctx, "_http._tcp.example.net.", registry.Instance{ID: "web-1", IP: net.ParseIP("10.0.0.1"), Port: 8080}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if instances := r.registered(ctx, "web-1._http._tcp.example.net."); len(instances) != 1 || instances[0].ID != "web-1" {
+		t.Errorf("expected the instance web-1, got %v", instances)
+	}
+
+	// A service name is not read as an instance first.
+	before := mr.CommandCount()
+	if instances := r.registered(ctx, "_http._tcp.example.net."); len(instances) != 1 || instances[0].ID != "web-1" {
+		t.Errorf("expected the instances of the service, got %v", instances)
+	}
+	if n := mr.CommandCount() - before; n != 2 {
+		t.Errorf("expected SMEMBERS and GET, got %d commands", n)
+	}
+}
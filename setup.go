@@ -2,6 +2,7 @@ package redis
 
 import (
 	"crypto/tls"
+	"errors"
 	"strconv"
 	"strings"
 	"time"
@@ -9,14 +10,20 @@ import (
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
 	mwtls "github.com/coredns/coredns/plugin/pkg/tls"
 	"github.com/coredns/coredns/plugin/pkg/upstream"
 	redisV8 "github.com/go-redis/redis/v8"
+	"github.com/kexirong/coredns-redis/registry"
+	"github.com/kexirong/coredns-redis/store"
+	"golang.org/x/sync/singleflight"
 )
 
 // go-redis有默认地址
 // const defaultAddress = ":6379"
 
+var log = clog.NewWithPlugin("redis")
+
 func init() { plugin.Register("redis", setup) }
 
 func setup(c *caddy.Controller) error {
@@ -25,99 +32,527 @@ func setup(c *caddy.Controller) error {
 		return plugin.Error("redis", err)
 	}
 
+	registerMetrics(c)
+	r.hooks(c)
+	for _, b := range r.backends {
+		b.hooks(c)
+	}
+
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
 		r.Next = next
+		for _, b := range r.backends {
+			b.Next = next
+		}
 		return r
 	})
 	return nil
 }
 
-func redisParse(c *caddy.Controller) (*Redis, error) {
-	redis := Redis{
-		KeyPrefix: "",
+// hooks runs the background parts of r along with the server.
+func (r *Redis) hooks(c *caddy.Controller) {
+	if r.conn != nil {
+		c.OnStartup(r.conn.start)
+		c.OnShutdown(r.conn.shutdown)
 	}
-	var (
-		tlsConfig *tls.Config
-		err       error
-		// addresses      = []string{defaultAddress}
-		addresses      []string
-		username       string
-		password       string
-		connectTimeout int
-		readTimeout    int
-	)
+	if r.health != nil {
+		c.OnStartup(r.health.start)
+		c.OnShutdown(r.health.shutdown)
+	}
+	if r.autoPTR != nil {
+		c.OnStartup(r.autoPTR.start)
+		c.OnShutdown(r.autoPTR.shutdown)
+	}
+	if r.stale != nil {
+		c.OnStartup(r.stale.start)
+		c.OnShutdown(r.stale.shutdown)
+	}
+	if r.mirror != nil {
+		c.OnStartup(r.mirror.start)
+		c.OnShutdown(r.mirror.shutdown)
+	}
+	if r.api != nil {
+		c.OnStartup(r.api.start)
+		c.OnShutdown(r.api.shutdown)
+	}
+	// Last, the hooks above may still use the client.
+	if r.Client != nil {
+		c.OnShutdown(r.Client.Close)
+	}
+}
+
+// config collects the options of a block, the plugin's own or a backend's,
+// to build a Redis from.
+type config struct {
+	redis *Redis
+
+	tlsConfig *tls.Config
+	// addresses      = []string{defaultAddress}
+	addresses       []string
+	username        string
+	password        string
+	healthInterval  time.Duration
+	serviceRegistry bool
+	autoPTRInterval time.Duration
+	storeOpts       store.Options
+	apiAddr         string
+	apiToken        string
+	pingMode        string
+	stalePath       string
+	staleRefresh    time.Duration
+	maxStale        time.Duration
+	mirrorZones     []string
+	clientOpts      redisV8.UniversalOptions
+}
 
-	redis.Upstream = upstream.New()
+func newConfig(zones []string) *config {
+	return &config{
+		redis: &Redis{
+			KeyPrefix: "",
+			Zones:     zones,
+			Upstream:  upstream.New(),
+		},
+		pingMode:     pingWarn,
+		staleRefresh: defaultStaleRefresh,
+		maxStale:     defaultMaxStale,
+	}
+}
 
+func redisParse(c *caddy.Controller) (*Redis, error) {
+	var (
+		cfg      *config
+		backends []*config
+	)
 	for c.Next() {
-		redis.Zones = plugin.OriginsFromArgsOrServerBlock(c.RemainingArgs(), c.ServerBlockKeys)
+		cfg = newConfig(plugin.OriginsFromArgsOrServerBlock(c.RemainingArgs(), c.ServerBlockKeys))
 		for c.NextBlock() {
-			switch c.Val() {
-			case "fallthrough":
-				redis.Fall.SetZonesFromArgs(c.RemainingArgs())
-			case "addresses":
-				addresses = c.RemainingArgs()
-				if len(addresses) == 0 {
-					return &Redis{}, c.ArgErr()
+			if c.Val() == "backend" {
+				b, err := parseBackend(c, cfg.redis.Zones)
+				if err != nil {
+					return &Redis{}, err
 				}
+				backends = append(backends, b)
+				continue
+			}
+			if err := cfg.option(c); err != nil {
+				return &Redis{}, err
+			}
+		}
+	}
 
-			case "username":
-				if !c.NextArg() {
-					return &Redis{}, c.ArgErr()
-				}
-				username = c.Val()
+	redis := cfg.redis
+	for _, b := range backends {
+		backend, err := b.build(c)
+		if err != nil {
+			return &Redis{}, err
+		}
+		redis.backends = append(redis.backends, backend)
+	}
+	if redis.ownZones() == 0 {
+		// Everything is served by the backends, the block's own redis
+		// isn't used.
+		if cfg.apiAddr != "" {
+			return &Redis{}, c.Err("api needs zones not served by a backend")
+		}
+		return redis, nil
+	}
+	if _, err := cfg.build(c); err != nil {
+		return &Redis{}, err
+	}
+	return redis, nil
+}
 
-			case "password":
-				if !c.NextArg() {
-					return &Redis{}, c.ArgErr()
-				}
-				password = c.Val()
+// parseBackend parses a block "backend ZONE { ... }", with the options of
+// the plugin's block but backend and api, for a zone of the plugin.
+func parseBackend(c *caddy.Controller, zones []string) (*config, error) {
+	if !c.NextArg() {
+		return nil, c.ArgErr()
+	}
+	zone := plugin.Host(c.Val()).NormalizeExact()
+	if len(zone) != 1 || plugin.Zones(zones).Matches(zone[0]) == "" {
+		return nil, c.Errf("backend zone '%s' is not a zone of the plugin", c.Val())
+	}
+	cfg := newConfig(zone)
+	cfg.redis.backend = zone[0]
 
-			case "key_prefix":
-				if !c.NextArg() {
-					return &Redis{}, c.ArgErr()
-				}
-				redis.KeyPrefix = c.Val()
-				if strings.HasSuffix(redis.KeyPrefix, ":") {
-					redis.KeyPrefix = strings.Trim(redis.KeyPrefix, ":")
-				}
+	if !c.NextArg() || c.Val() != "{" {
+		return nil, c.Err("expected '{' after the backend zone")
+	}
+	if c.NextArg() {
+		return nil, c.Errf("unexpected '%s' after '{'", c.Val())
+	}
+	// Every option is a line of its own, up to the closing '}'.
+	for c.NextLine() {
+		switch c.Val() {
+		case "}":
+			if c.NextArg() {
+				return nil, c.Errf("unexpected '%s' after '}'", c.Val())
+			}
+			return cfg, nil
+		case "backend", "api":
+			return nil, c.Errf("%s is not supported in a backend", c.Val())
+		}
+		if err := cfg.option(c); err != nil {
+			return nil, err
+		}
+		if c.NextArg() {
+			return nil, c.Errf("unexpected '%s'", c.Val())
+		}
+	}
+	return nil, c.Err("unterminated backend block")
+}
 
-			case "tls": // cert key cacertfile
-				args := c.RemainingArgs()
-				tlsConfig, err = mwtls.NewTLSConfigFromArgs(args...)
-				if err != nil {
-					return &Redis{}, err
-				}
+// option parses the option at the cursor of c.
+func (cfg *config) option(c *caddy.Controller) error {
+	var err error
+	switch c.Val() {
+	case "fallthrough":
+		cfg.redis.Fall.SetZonesFromArgs(c.RemainingArgs())
+	case "addresses":
+		cfg.addresses = c.RemainingArgs()
+		if len(cfg.addresses) == 0 {
+			return c.ArgErr()
+		}
 
-			case "connect_timeout":
-				if !c.NextArg() {
-					return &Redis{}, c.ArgErr()
-				}
-				connectTimeout, _ = strconv.Atoi(c.Val())
+	case "username":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		cfg.username = c.Val()
 
-			case "read_timeout":
-				if !c.NextArg() {
-					return &Redis{}, c.ArgErr()
-				}
-				readTimeout, _ = strconv.Atoi(c.Val())
+	case "password":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		cfg.password = c.Val()
+
+	case "master_name":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		cfg.clientOpts.MasterName = c.Val()
+
+	case "sentinel_username":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		cfg.clientOpts.SentinelUsername = c.Val()
+
+	case "sentinel_password":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		cfg.clientOpts.SentinelPassword = c.Val()
+
+	case "db":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		cfg.clientOpts.DB, err = strconv.Atoi(c.Val())
+		if err != nil || cfg.clientOpts.DB < 0 {
+			return c.Errf("invalid db '%s'", c.Val())
+		}
+
+	case "route_by_latency":
+		cfg.clientOpts.RouteByLatency = true
+
+	case "route_randomly":
+		cfg.clientOpts.RouteRandomly = true
+
+	case "read_only":
+		cfg.clientOpts.ReadOnly = true
+
+	case "key_prefix":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		cfg.redis.KeyPrefix = c.Val()
+		if strings.HasSuffix(cfg.redis.KeyPrefix, ":") {
+			cfg.redis.KeyPrefix = strings.Trim(cfg.redis.KeyPrefix, ":")
+		}
+
+	case "tls": // cert key cacertfile
+		args := c.RemainingArgs()
+		cfg.tlsConfig, err = mwtls.NewTLSConfigFromArgs(args...)
+		if err != nil {
+			return err
+		}
+
+	case "connect_timeout":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		cfg.clientOpts.DialTimeout, err = parseDuration(c.Val())
+		if err != nil {
+			return c.Errf("invalid connect_timeout '%s'", c.Val())
+		}
+
+	case "read_timeout":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		cfg.clientOpts.ReadTimeout, err = parseDuration(c.Val())
+		if err != nil {
+			return c.Errf("invalid read_timeout '%s'", c.Val())
+		}
+
+	case "write_timeout":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		cfg.clientOpts.WriteTimeout, err = parseDuration(c.Val())
+		if err != nil {
+			return c.Errf("invalid write_timeout '%s'", c.Val())
+		}
 
-			default:
-				if c.Val() != "}" {
-					return &Redis{}, c.Errf("unknown property '%s'", c.Val())
+	case "query_timeout":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		cfg.redis.queryTimeout, err = parseDuration(c.Val())
+		if err != nil {
+			return c.Errf("invalid query_timeout '%s'", c.Val())
+		}
+
+	case "pool_size":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		cfg.clientOpts.PoolSize, err = strconv.Atoi(c.Val())
+		if err != nil || cfg.clientOpts.PoolSize < 1 {
+			return c.Errf("invalid pool_size '%s'", c.Val())
+		}
+
+	case "min_idle_conns":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		cfg.clientOpts.MinIdleConns, err = strconv.Atoi(c.Val())
+		if err != nil || cfg.clientOpts.MinIdleConns < 0 {
+			return c.Errf("invalid min_idle_conns '%s'", c.Val())
+		}
+
+	case "max_retries":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		cfg.clientOpts.MaxRetries, err = strconv.Atoi(c.Val())
+		if err != nil || cfg.clientOpts.MaxRetries < 0 {
+			return c.Errf("invalid max_retries '%s'", c.Val())
+		}
+		// go-redis takes 0 for its default, -1 for none.
+		if cfg.clientOpts.MaxRetries == 0 {
+			cfg.clientOpts.MaxRetries = -1
+		}
+
+	case "retry_backoff":
+		args := c.RemainingArgs()
+		if len(args) != 2 {
+			return c.ArgErr()
+		}
+		cfg.clientOpts.MinRetryBackoff, err = parseDuration(args[0])
+		if err != nil {
+			return c.Errf("invalid retry_backoff '%s'", args[0])
+		}
+		cfg.clientOpts.MaxRetryBackoff, err = parseDuration(args[1])
+		if err != nil || cfg.clientOpts.MaxRetryBackoff < cfg.clientOpts.MinRetryBackoff {
+			return c.Errf("invalid retry_backoff '%s'", args[1])
+		}
+
+	case "ping":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		cfg.pingMode = c.Val()
+		if cfg.pingMode != pingFail && cfg.pingMode != pingWarn {
+			return c.Errf("unknown ping mode '%s'", cfg.pingMode)
+		}
+
+	case "health_check":
+		cfg.healthInterval = defaultHealthInterval
+		if c.NextArg() {
+			cfg.healthInterval, err = parseDuration(c.Val())
+			if err != nil {
+				return c.Errf("invalid health_check interval '%s'", c.Val())
+			}
+		}
+
+	case "auto_ptr":
+		cfg.autoPTRInterval = defaultAutoPTRInterval
+		if c.NextArg() {
+			cfg.autoPTRInterval, err = parseDuration(c.Val())
+			if err != nil {
+				return c.Errf("invalid auto_ptr interval '%s'", c.Val())
+			}
+		}
+
+	case "serve_stale":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		cfg.stalePath = c.Val()
+		if c.NextArg() {
+			cfg.staleRefresh, err = time.ParseDuration(c.Val())
+			if err != nil || cfg.staleRefresh <= 0 {
+				return c.Errf("invalid serve_stale refresh '%s'", c.Val())
+			}
+		}
+		if c.NextArg() {
+			cfg.maxStale, err = time.ParseDuration(c.Val())
+			if err != nil || cfg.maxStale <= 0 {
+				return c.Errf("invalid serve_stale max staleness '%s'", c.Val())
+			}
+		}
+
+	case "mirror":
+		cfg.mirrorZones = plugin.OriginsFromArgsOrServerBlock(c.RemainingArgs(), cfg.redis.Zones)
+		for _, zone := range cfg.mirrorZones {
+			if plugin.Zones(cfg.redis.Zones).Matches(zone) == "" {
+				return c.Errf("mirror zone '%s' is not a zone of the plugin", zone)
+			}
+		}
+
+	case "layout":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		cfg.storeOpts.Layout = c.Val()
+		switch cfg.storeOpts.Layout {
+		case store.LayoutNameHash:
+		case store.LayoutZoneHash:
+			if c.NextArg() {
+				cfg.storeOpts.Shards, err = strconv.Atoi(c.Val())
+				if err != nil || cfg.storeOpts.Shards < 1 {
+					return c.Errf("invalid number of shards '%s'", c.Val())
 				}
 			}
+		default:
+			return c.Errf("unknown layout '%s'", cfg.storeOpts.Layout)
+		}
 
+	case "format":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		cfg.storeOpts.Format = c.Val()
+		if cfg.storeOpts.Format != store.FormatJSON && cfg.storeOpts.Format != store.FormatSet && cfg.storeOpts.Format != store.FormatReJSON {
+			return c.Errf("unknown format '%s'", cfg.storeOpts.Format)
+		}
+
+	case "encoding":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		cfg.storeOpts.Encoding = c.Val()
+		if cfg.storeOpts.Encoding != store.EncodingJSON && cfg.storeOpts.Encoding != store.EncodingWire {
+			return c.Errf("unknown encoding '%s'", cfg.storeOpts.Encoding)
 		}
+
+	case "legacy_keys":
+		cfg.redis.LegacyKeys = true
+
+	case "service_registry":
+		cfg.serviceRegistry = true
+
+	case "api":
+		args := c.RemainingArgs()
+		if len(args) != 2 {
+			return c.ArgErr()
+		}
+		cfg.apiAddr, cfg.apiToken = args[0], args[1]
+
+	default:
+		return c.Errf("unknown property '%s'", c.Val())
+	}
+	return nil
+}
+
+// build creates the client and the parts of the Redis of cfg.
+func (cfg *config) build(c *caddy.Controller) (*Redis, error) {
+	r := cfg.redis
+	cfg.clientOpts.Addrs = cfg.addresses
+	cfg.clientOpts.Username = cfg.username
+	cfg.clientOpts.Password = cfg.password
+	cfg.clientOpts.TLSConfig = cfg.tlsConfig
+	if err := checkClientOptions(&cfg.clientOpts); err != nil {
+		return nil, c.Err(err.Error())
+	}
+	r.Client = redisV8.NewUniversalClient(&cfg.clientOpts)
+
+	r.Client.AddHook(tracingHook{})
+	r.flight = new(singleflight.Group)
+	r.conn = newConnChecker(r.Client, cfg.pingMode)
+
+	if cfg.serviceRegistry {
+		r.registry = registry.New(r.Client, r.KeyPrefix)
+	}
+
+	var err error
+	cfg.storeOpts.Zones = r.Zones
+	r.store, err = store.New(r.Client, r.KeyPrefix, cfg.storeOpts)
+	if err != nil {
+		return nil, c.Err(err.Error())
+	}
+
+	if cfg.autoPTRInterval > 0 {
+		r.autoPTR = newAutoPTR(r.store, r.Zones, cfg.autoPTRInterval)
+	}
+
+	if cfg.stalePath != "" {
+		r.stale = newSnapshot(r.store, cfg.stalePath, r.Zones, cfg.staleRefresh, cfg.maxStale)
 	}
-	redis.Client = redisV8.NewUniversalClient(&redisV8.UniversalOptions{
-		Addrs:       addresses,
-		Username:    username,
-		Password:    password,
-		DialTimeout: time.Second * time.Duration(connectTimeout),
-		ReadTimeout: time.Duration(readTimeout) * time.Second,
-		TLSConfig:   tlsConfig,
-	})
 
-	return &redis, nil
+	if len(cfg.mirrorZones) > 0 {
+		if _, ok := r.Client.(*redisV8.ClusterClient); ok {
+			return nil, c.Err("mirror does not work on a cluster")
+		}
+		r.mirror = newMirror(r.store, cfg.mirrorZones)
+	}
+
+	if cfg.apiAddr != "" {
+		r.api = newAPI(r, cfg.apiAddr, cfg.apiToken)
+	}
+
+	if cfg.healthInterval > 0 {
+		r.health = newHealthChecker(r.Client, r.KeyPrefix, cfg.healthInterval)
+	}
+
+	return r, nil
+}
+
+// parseDuration parses a duration, taking bare numbers as seconds as the
+// timeouts used to be.
+func parseDuration(s string) (time.Duration, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		s = strconv.Itoa(n) + "s"
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, errors.New("duration must be positive")
+	}
+	return d, nil
+}
+
+// checkClientOptions rejects the options the kind of client picked by
+// NewUniversalClient would ignore: a sentinel client with master_name, a
+// cluster client with several addresses, a single node client otherwise.
+func checkClientOptions(opts *redisV8.UniversalOptions) error {
+	sentinel := opts.MasterName != ""
+	cluster := !sentinel && len(opts.Addrs) > 1
 
+	if !sentinel && (opts.SentinelUsername != "" || opts.SentinelPassword != "") {
+		return errors.New("sentinel_username and sentinel_password need master_name")
+	}
+	if cluster && opts.DB != 0 {
+		return errors.New("db is not supported by a cluster")
+	}
+	if !cluster && (opts.RouteByLatency || opts.RouteRandomly || opts.ReadOnly) {
+		return errors.New("route_by_latency, route_randomly and read_only need a cluster, several addresses without master_name")
+	}
+	if opts.RouteByLatency && opts.RouteRandomly {
+		return errors.New("route_by_latency and route_randomly are exclusive")
+	}
+	return nil
 }
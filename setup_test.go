@@ -0,0 +1,104 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	redisV8 "github.com/go-redis/redis/v8"
+)
+
+func TestCheckClientOptions(t *testing.T) {
+	cluster := []string{"10.0.0.1:6379", "10.0.0.2:6379"}
+	tests := []struct {
+		opts redisV8.UniversalOptions
+		ok   bool
+	}{
+		{redisV8.UniversalOptions{Addrs: []string{"10.0.0.1:6379"}, DB: 2}, true},
+		{redisV8.UniversalOptions{Addrs: cluster, MasterName: "dns", SentinelPassword: "secret", DB: 2}, true},
+		{redisV8.UniversalOptions{Addrs: cluster, RouteByLatency: true}, true},
+		{redisV8.UniversalOptions{Addrs: cluster, ReadOnly: true}, true},
+		{redisV8.UniversalOptions{Addrs: []string{"10.0.0.1:6379"}, SentinelPassword: "secret"}, false},
+		{redisV8.UniversalOptions{Addrs: cluster, DB: 2}, false},
+		{redisV8.UniversalOptions{Addrs: []string{"10.0.0.1:6379"}, RouteRandomly: true}, false},
+		{redisV8.UniversalOptions{Addrs: cluster, MasterName: "dns", ReadOnly: true}, false},
+		{redisV8.UniversalOptions{Addrs: cluster, RouteByLatency: true, RouteRandomly: true}, false},
+	}
+	for i, tt := range tests {
+		if err := checkClientOptions(&tt.opts); (err == nil) != tt.ok {
+			t.Errorf("test %d: got %v, want ok %v", i, err, tt.ok)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"2", 2 * time.Second, true},
+		{"250ms", 250 * time.Millisecond, true},
+		{"1m30s", 90 * time.Second, true},
+		{"0", 0, false},
+		{"-1s", 0, false},
+		{"2 s", 0, false},
+		{"fast", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseDuration(tt.in)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("parseDuration(%q) = %v, %v, want %v, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRedisParse(t *testing.T) {
+	tests := []struct {
+		input string
+		ok    bool
+	}{
+		{"redis example.net", true},
+		{`redis example.net {
+			ping fail
+			pool_size 20
+			min_idle_conns 2
+			max_retries 2
+			retry_backoff 8ms 512ms
+			write_timeout 2s
+			query_timeout 500ms
+		}`, true},
+		{"redis example.net {\n ping warn\n}", true},
+		{"redis example.net {\n ping\n}", false},
+		{"redis example.net {\n ping sometimes\n}", false},
+		{"redis example.net {\n pool_size 0\n}", false},
+		{"redis example.net {\n query_timeout fast\n}", false},
+		{"redis example.net {\n unknown\n}", false},
+		{"redis example.net {\n health_check 5\n auto_ptr 10m\n}", true},
+		{"redis example.net {\n health_check 0\n}", false},
+		{"redis example.net {\n health_check -1s\n}", false},
+		{"redis example.net {\n auto_ptr 0\n}", false},
+	}
+	for i, tt := range tests {
+		r, err := redisParse(caddy.NewTestController("dns", tt.input))
+		if (err == nil) != tt.ok {
+			t.Errorf("test %d: got %v, want ok %v", i, err, tt.ok)
+			continue
+		}
+		if err == nil {
+			r.Client.Close()
+		}
+	}
+
+	r, err := redisParse(caddy.NewTestController("dns", tests[1].input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Client.Close()
+	opts := r.Client.(*redisV8.Client).Options()
+	if r.conn.mode != pingFail || opts.PoolSize != 20 || opts.MinIdleConns != 2 || opts.MaxRetries != 2 ||
+		opts.MinRetryBackoff != 8*time.Millisecond || opts.MaxRetryBackoff != 512*time.Millisecond ||
+		opts.WriteTimeout != 2*time.Second || r.queryTimeout != 500*time.Millisecond {
+		t.Errorf("options not applied: ping %s, %+v, query timeout %s", r.conn.mode, opts, r.queryTimeout)
+	}
+}
@@ -0,0 +1,235 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kexirong/coredns-redis/keys"
+	"github.com/kexirong/coredns-redis/store"
+	"github.com/miekg/dns"
+)
+
+const (
+	// staleTTL is the TTL of stale answers, as of RFC 8767.
+	staleTTL = 30
+	// staleAnswerTimeout is the client response timer of RFC 8767: reads
+	// after it are answered from the snapshot rather than waited for.
+	staleAnswerTimeout = 1800 * time.Millisecond
+
+	defaultStaleRefresh = time.Minute
+	defaultMaxStale     = 24 * time.Hour
+)
+
+var errNoSnapshot = errors.New("no usable snapshot")
+
+// snapshot keeps a copy of the zones on disk, to answer from while redis
+// can't be reached. It is refreshed every refresh, and used for at most
+// maxStale after it was taken.
+type snapshot struct {
+	store    *store.Store
+	path     string
+	zones    []string
+	refresh  time.Duration
+	maxStale time.Duration
+
+	mu    sync.RWMutex
+	taken time.Time
+	keys  map[string]map[string]string
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// snapshotFile is the content of the snapshot on disk.
+type snapshotFile struct {
+	Taken time.Time                    `json:"taken"`
+	Keys  map[string]map[string]string `json:"keys"`
+}
+
+func newSnapshot(s *store.Store, path string, zones []string, refresh, maxStale time.Duration) *snapshot {
+	return &snapshot{store: s, path: path, zones: zones, refresh: refresh, maxStale: maxStale}
+}
+
+// start loads the snapshot of a previous run, in case redis is already
+// unreachable, and then keeps taking new ones.
+func (s *snapshot) start() error {
+	if err := s.load(); err != nil && !os.IsNotExist(err) {
+		log.Warningf("snapshot: %s", err)
+	}
+
+	s.stop = make(chan struct{})
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.run()
+		ticker := time.NewTicker(s.refresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.run()
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *snapshot) shutdown() error {
+	if s.stop != nil {
+		close(s.stop)
+		s.wg.Wait()
+		s.stop = nil
+	}
+	return nil
+}
+
+func (s *snapshot) run() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.refresh)
+	defer cancel()
+	if err := s.take(ctx); err != nil {
+		log.Warningf("snapshot: %s", err)
+	}
+}
+
+// take reads the zones from redis and saves them. A failed read keeps the
+// previous snapshot.
+func (s *snapshot) take(ctx context.Context) error {
+	keys := make(map[string]map[string]string)
+	for _, zone := range s.zones {
+		err := s.store.Walk(ctx, zone, func(key string, fields map[string]string) error {
+			keys[key] = fields
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return s.save(time.Now(), keys)
+}
+
+func (s *snapshot) save(taken time.Time, keys map[string]map[string]string) error {
+	b, err := json.Marshal(snapshotFile{Taken: taken, Keys: keys})
+	if err != nil {
+		return err
+	}
+	// Written aside and renamed, a crash must not leave half a snapshot.
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.taken, s.keys = taken, keys
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *snapshot) load() error {
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var f snapshotFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.taken, s.keys = f.Taken, f.Keys
+	s.mu.Unlock()
+	return nil
+}
+
+// get returns field of key, or whether key has any for an empty field, as of
+// the snapshot, also under the legacy key
+// with legacy set, errKeyNotFound if it had none, or errNoSnapshot if there
+// is none or it is too old.
+func (s *snapshot) get(key, field string, legacy bool) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.fresh() {
+		return "", errNoSnapshot
+	}
+	lookup := func(key string) (string, bool) {
+		if field == "" {
+			return "", len(s.keys[key]) > 0
+		}
+		val, ok := s.keys[key][field]
+		return val, ok
+	}
+	val, ok := lookup(key)
+	if !ok && legacy {
+		val, ok = lookup(keys.Legacy(key, s.store.Prefix()))
+	}
+	if !ok {
+		return "", errKeyNotFound
+	}
+	return val, nil
+}
+
+// usable reports whether there is a snapshot to answer from.
+func (s *snapshot) usable() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.fresh()
+}
+
+func (s *snapshot) fresh() bool {
+	return s.keys != nil && time.Since(s.taken) <= s.maxStale
+}
+
+type staleKey struct{}
+
+// staleFlag is set by markStale once the snapshot was used for the query,
+// and holds when its client response timer expires.
+type staleFlag struct {
+	used     int32
+	deadline time.Time
+}
+
+// withStaleFlag returns ctx with a stale flag, and a client response timer
+// that expires after staleAnswerTimeout.
+func withStaleFlag(ctx context.Context) context.Context {
+	return context.WithValue(ctx, staleKey{}, &staleFlag{deadline: time.Now().Add(staleAnswerTimeout)})
+}
+
+func markStale(ctx context.Context) {
+	if flag, ok := ctx.Value(staleKey{}).(*staleFlag); ok {
+		atomic.StoreInt32(&flag.used, 1)
+	}
+}
+
+func isStale(ctx context.Context) bool {
+	flag, ok := ctx.Value(staleKey{}).(*staleFlag)
+	return ok && atomic.LoadInt32(&flag.used) == 1
+}
+
+// staleDeadline returns the expiry of the client response timer of the
+// query, if it has one.
+func staleDeadline(ctx context.Context) (time.Time, bool) {
+	flag, ok := ctx.Value(staleKey{}).(*staleFlag)
+	if !ok {
+		return time.Time{}, false
+	}
+	return flag.deadline, true
+}
+
+// staleTTLs caps the TTLs of records from the snapshot to staleTTL.
+func staleTTLs(records []dns.RR) {
+	for _, rr := range records {
+		if rr.Header().Ttl > staleTTL {
+			rr.Header().Ttl = staleTTL
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package redis
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	redisV8 "github.com/go-redis/redis/v8"
+	"github.com/kexirong/coredns-redis/keys"
+	"github.com/kexirong/coredns-redis/store"
+)
+
+func TestServeStale(t *testing.T) {
+	client := redisV8.NewUniversalClient(&redisV8.UniversalOptions{Addrs: []string{"127.0.0.1:1"}, MaxRetries: -1})
+	defer client.Close()
+	s, err := store.New(client, "coredns", store.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	key := Key("www.example.net.", "coredns")
+	saved := newSnapshot(s, path, []string{"example.net."}, time.Minute, time.Hour)
+	if err := saved.save(time.Now(), map[string]map[string]string{key: {"A": `[{"ttl":300,"ip":"1.1.1.1"}]`}}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Redis{Client: client, KeyPrefix: "coredns", store: s, stale: newSnapshot(s, path, nil, time.Minute, time.Hour)}
+	if _, err := r.get(context.Background(), key, "A"); err == nil || err == errKeyNotFound {
+		t.Fatalf("get without snapshot: %v, want the redis error", err)
+	}
+
+	if err := r.stale.load(); err != nil {
+		t.Fatal(err)
+	}
+	ctx := withStaleFlag(context.Background())
+	if val, err := r.get(ctx, key, "A"); err != nil || val != `[{"ttl":300,"ip":"1.1.1.1"}]` {
+		t.Errorf("get = %q, %v", val, err)
+	}
+	if !isStale(ctx) {
+		t.Error("answer not flagged stale")
+	}
+	if _, err := r.get(ctx, key, "AAAA"); err != errKeyNotFound {
+		t.Errorf("get of a missing field: %v, want errKeyNotFound", err)
+	}
+
+	// Too old.
+	r.stale.taken = time.Now().Add(-2 * time.Hour)
+	if _, err := r.get(ctx, key, "A"); err == nil || err == errKeyNotFound {
+		t.Errorf("get from an expired snapshot: %v, want the redis error", err)
+	}
+}
+
+func TestServeStaleSlow(t *testing.T) {
+	// A redis that accepts connections and never answers.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	client := redisV8.NewUniversalClient(&redisV8.UniversalOptions{Addrs: []string{ln.Addr().String()}, MaxRetries: -1})
+	defer client.Close()
+	s, err := store.New(client, "coredns", store.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := Key("a:b.example.net.", "coredns")
+	r := &Redis{Client: client, KeyPrefix: "coredns", store: s, stale: newSnapshot(s, filepath.Join(t.TempDir(), "snapshot.json"), nil, time.Minute, time.Hour)}
+	if err := r.stale.save(time.Now(), map[string]map[string]string{keys.Legacy(key, "coredns"): {"A": `[{"ttl":300,"ip":"1.1.1.1"}]`}}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.WithValue(context.Background(), staleKey{}, &staleFlag{deadline: time.Now().Add(50 * time.Millisecond)})
+	if _, err := r.get(ctx, key, "A"); err != errKeyNotFound {
+		t.Errorf("get without legacy_keys: %v, want errKeyNotFound", err)
+	}
+	r.LegacyKeys = true
+	start := time.Now()
+	if val, err := r.get(ctx, key, "A"); err != nil || val != `[{"ttl":300,"ip":"1.1.1.1"}]` {
+		t.Errorf("get = %q, %v", val, err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("answered after %s, want the client response timer", time.Since(start))
+	}
+}
@@ -0,0 +1,294 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	redisV8 "github.com/go-redis/redis/v8"
+	"github.com/kexirong/coredns-redis/keys"
+)
+
+const (
+	FormatJSON   = "json"
+	FormatSet    = "set"
+	FormatReJSON = "rejson"
+)
+
+// ErrNotJSON is returned for writes of values other than JSON items in the
+// formats holding only those.
+var ErrNotJSON = errors.New("format holds JSON items only")
+
+// singleFields hold a single item rather than an array of them.
+var singleFields = map[string]bool{"SOA": true, FieldFailover: true}
+
+// SetKey returns the key of the set holding the items of type rrtype of the
+// name of key in the set format.
+func SetKey(key, rrtype string) string {
+	return key + "/" + rrtype
+}
+
+// TypesKey returns the key of the hash indexing the types of the name of key
+// in the set format, by their number of items.
+func TypesKey(key string) string {
+	return SetKey(key, typesField)
+}
+
+// typesField names the index of the types, it is no type.
+const typesField = "types"
+
+// nameSet keeps every RRset in a set of its own, one item per member, so
+// that single items can be added and removed with SADD and SREM, and indexes
+// the types of every name:
+//
+//	coredns:net:example:www/A        {"ttl":30,"ip":"1.1.1.1"}, {"ttl":30,"ip":"1.1.1.2"}
+//	coredns:net:example:www/types    A: 2
+type nameSet struct {
+	client redisV8.UniversalClient
+	prefix string
+}
+
+func (l nameSet) get(ctx context.Context, c redisV8.Cmdable, key, field string) (string, error) {
+	members, err := c.SMembers(ctx, SetKey(key, field)).Result()
+	if err != nil {
+		return "", err
+	}
+	if len(members) == 0 {
+		return "", redisV8.Nil
+	}
+	return setValue(field, members), nil
+}
+
+// fields reads the sets of the types in the index of key.
+func (l nameSet) fields(ctx context.Context, c redisV8.Cmdable, key string) (map[string]string, error) {
+	all, err := c.HKeys(ctx, TypesKey(key)).Result()
+	if err != nil || len(all) == 0 {
+		return map[string]string{}, err
+	}
+	cmds := make([]*redisV8.StringSliceCmd, len(all))
+	_, err = c.Pipelined(ctx, func(pipe redisV8.Pipeliner) error {
+		for i, field := range all {
+			cmds[i] = pipe.SMembers(ctx, SetKey(key, field))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string)
+	for i, cmd := range cmds {
+		if members := cmd.Val(); len(members) > 0 {
+			fields[all[i]] = setValue(all[i], members)
+		}
+	}
+	return fields, nil
+}
+
+func (l nameSet) set(ctx context.Context, pipe redisV8.Pipeliner, key, field, val string) error {
+	members := []interface{}{val}
+	if !singleFields[field] {
+		var items []json.RawMessage
+		if err := json.Unmarshal([]byte(val), &items); err != nil {
+			return ErrNotJSON
+		}
+		members = members[:0]
+		for _, item := range items {
+			members = append(members, string(item))
+		}
+	} else if !IsJSON(val) {
+		return ErrNotJSON
+	}
+
+	setKey := SetKey(key, field)
+	pipe.Del(ctx, setKey)
+	if len(members) == 0 {
+		pipe.HDel(ctx, TypesKey(key), field)
+		return nil
+	}
+	pipe.SAdd(ctx, setKey, members...)
+	pipe.HSet(ctx, TypesKey(key), field, len(members))
+	return nil
+}
+
+func (l nameSet) del(ctx context.Context, pipe redisV8.Pipeliner, key, field string) {
+	pipe.Del(ctx, SetKey(key, field))
+	pipe.HDel(ctx, TypesKey(key), field)
+}
+
+// keys is the index alone, which every write of key sets.
+func (l nameSet) keys(key string) []string {
+	return []string{TypesKey(key)}
+}
+
+func (l nameSet) owner(redisKey string) (string, bool) {
+	i := strings.LastIndex(redisKey, "/")
+	if i < 0 || IsInternalKey(redisKey, l.prefix) {
+		return "", false
+	}
+	return redisKey[:i], true
+}
+
+func (l nameSet) walk(ctx context.Context, zone string, fn func(key string, fields map[string]string) error) error {
+	zoneKey := keys.Key(zone, l.prefix)
+	names := make(map[string]map[string]string)
+
+	err := scanKeys(ctx, l.client, zoneKey+"*/*", func(setKey string) error {
+		i := strings.LastIndex(setKey, "/")
+		key, field := setKey[:i], setKey[i+1:]
+		if field == typesField {
+			return nil
+		}
+		if zoneKey != "" && key != zoneKey && !strings.HasPrefix(key, zoneKey+keys.Separator) {
+			return nil
+		}
+		if IsInternalKey(key, l.prefix) {
+			return nil
+		}
+
+		members, err := l.client.SMembers(ctx, setKey).Result()
+		if err != nil || len(members) == 0 {
+			return nil
+		}
+		if names[key] == nil {
+			names[key] = make(map[string]string)
+		}
+		names[key][field] = setValue(field, members)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for key, fields := range names {
+		if err := fn(key, fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// do sends a command without a method of Cmdable, as of RedisJSON, to c.
+func do(ctx context.Context, c redisV8.Cmdable, args ...interface{}) *redisV8.Cmd {
+	cmd := redisV8.NewCmd(ctx, args...)
+	if p, ok := c.(interface {
+		Process(context.Context, redisV8.Cmder) error
+	}); ok {
+		p.Process(ctx, cmd)
+	} else {
+		cmd.SetErr(errors.New("client can't send " + args[0].(string)))
+	}
+	return cmd
+}
+
+// setValue joins the members of a set into the JSON of the field.
+func setValue(field string, members []string) string {
+	if singleFields[field] {
+		return members[0]
+	}
+	return "[" + strings.Join(members, ",") + "]"
+}
+
+// nameJSON keeps every name in a RedisJSON document of its own, with a
+// member per type, so that items can be added with JSON.ARRAPPEND and
+// removed with JSON.ARRPOP or JSON.DEL:
+//
+//	coredns:net:example:www    {"A":[{"ttl":30,"ip":"1.1.1.1"}],"TXT":[...]}
+type nameJSON struct {
+	client redisV8.UniversalClient
+	prefix string
+}
+
+func (l nameJSON) get(ctx context.Context, c redisV8.Cmdable, key, field string) (string, error) {
+	val, err := do(ctx, c, "JSON.GET", key, "$."+field).Text()
+	if err != nil {
+		return "", err
+	}
+
+	// JSONPath returns the array of all matches.
+	var matches []json.RawMessage
+	if err := json.Unmarshal([]byte(val), &matches); err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", redisV8.Nil
+	}
+	return string(matches[0]), nil
+}
+
+func (l nameJSON) fields(ctx context.Context, c redisV8.Cmdable, key string) (map[string]string, error) {
+	fields := make(map[string]string)
+	val, err := do(ctx, c, "JSON.GET", key).Text()
+	if err == redisV8.Nil {
+		return fields, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(val), &doc); err != nil {
+		return nil, err
+	}
+	for field, raw := range doc {
+		fields[field] = string(raw)
+	}
+	return fields, nil
+}
+
+// set creates the document if needed, which is a nil reply (redisV8.Nil) for
+// an existing one.
+func (l nameJSON) set(ctx context.Context, pipe redisV8.Pipeliner, key, field, val string) error {
+	if !IsJSON(val) {
+		return ErrNotJSON
+	}
+	pipe.Do(ctx, "JSON.SET", key, "$", "{}", "NX")
+	pipe.Do(ctx, "JSON.SET", key, "$."+field, val)
+	return nil
+}
+
+func (l nameJSON) del(ctx context.Context, pipe redisV8.Pipeliner, key, field string) {
+	pipe.Do(ctx, "JSON.DEL", key, "$."+field)
+}
+
+func (l nameJSON) keys(key string) []string { return []string{key} }
+
+func (l nameJSON) owner(redisKey string) (string, bool) {
+	return redisKey, !IsInternalKey(redisKey, l.prefix)
+}
+
+func (l nameJSON) walk(ctx context.Context, zone string, fn func(key string, fields map[string]string) error) error {
+	zoneKey := keys.Key(zone, l.prefix)
+
+	visit := func(key string) error {
+		if IsInternalKey(key, l.prefix) {
+			return nil
+		}
+		val, err := l.client.Do(ctx, "JSON.GET", key).Text()
+		if err != nil {
+			// Not a document, or gone in between.
+			return nil
+		}
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(val), &doc); err != nil {
+			return nil
+		}
+		fields := make(map[string]string, len(doc))
+		for field, raw := range doc {
+			fields[field] = string(raw)
+		}
+		return fn(key, fields)
+	}
+
+	match := zoneKey + keys.Separator + "*"
+	if zoneKey == "" {
+		match = "*"
+	}
+	if err := scanKeys(ctx, l.client, match, visit); err != nil {
+		return err
+	}
+	if zoneKey == "" {
+		return nil
+	}
+	return visit(zoneKey)
+}
@@ -0,0 +1,217 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	redisV8 "github.com/go-redis/redis/v8"
+)
+
+var ctx = context.Background()
+
+func testClient(t *testing.T) (redisV8.UniversalClient, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	client := redisV8.NewUniversalClient(&redisV8.UniversalOptions{Addrs: []string{mr.Addr()}})
+	t.Cleanup(func() { client.Close() })
+	return client, mr
+}
+
+func TestNameSet(t *testing.T) {
+	client, mr := testClient(t)
+	s, err := New(client, "coredns", Options{Format: FormatSet})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := `[{"ttl":30,"ip":"192.0.2.1"},{"ttl":30,"ip":"192.0.2.2"}]`
+	if err := s.SetField(ctx, "example.net.", "www.example.net.", "A", a); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetField(ctx, "example.net.", "www.example.net.", "TXT", `[{"ttl":30,"text":"hello"}]`); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetField(ctx, "example.net.", "www.example.net.", "MX", "30 IN MX 10 mail"); err != ErrNotJSON {
+		t.Errorf("expected ErrNotJSON for zone file text, got %v", err)
+	}
+	if members, _ := mr.Members("coredns:net:example:www/A"); len(members) != 2 {
+		t.Errorf("expected a member per item, got %q", members)
+	}
+	if n := mr.HGet(TypesKey("coredns:net:example:www"), "A"); n != "2" {
+		t.Errorf("expected A with 2 items in the index, got %q", n)
+	}
+
+	val, err := s.Get(ctx, s.Key("www.example.net."), "A")
+	if err != nil || !sameItems(val, a) {
+		t.Errorf("expected %s, got %s (%v)", a, val, err)
+	}
+	if _, err := s.Get(ctx, s.Key("www.example.net."), "AAAA"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	mr.HSet(InternalKey("coredns", "ptr"), "192.0.2.1", `[{"host":"www.example.net."}]`)
+	if val, err := s.Get(ctx, InternalKey("coredns", "ptr"), "192.0.2.1"); err != nil || val != `[{"host":"www.example.net."}]` {
+		t.Errorf("expected the internal hash read as such, got %s (%v)", val, err)
+	}
+
+	fields, err := s.Fields(ctx, s.Key("www.example.net."))
+	if err != nil || len(fields) != 2 || fields["TXT"] != `[{"ttl":30,"text":"hello"}]` {
+		t.Errorf("expected A and TXT, got %v (%v)", fields, err)
+	}
+
+	names, err := s.ReadZone(ctx, "example.net.")
+	if err != nil || len(names) != 1 || len(names["coredns:net:example:www"]) != 2 {
+		t.Errorf("expected www with A and TXT, got %v (%v)", names, err)
+	}
+
+	if err := s.DeleteField(ctx, "example.net.", "www.example.net.", "A"); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists("coredns:net:example:www/A") {
+		t.Error("expected the set of A to be deleted")
+	}
+	if types, _ := mr.HKeys(TypesKey("coredns:net:example:www")); len(types) != 1 || types[0] != "TXT" {
+		t.Errorf("expected TXT alone in the index, got %v", types)
+	}
+}
+
+func TestNameJSON(t *testing.T) {
+	client, mr := testClient(t)
+	fakeJSON(t, mr)
+	l := nameJSON{client: client, prefix: "coredns"}
+	key := "coredns:net:example:www"
+
+	a := `[{"ttl":30,"ip":"192.0.2.1"}]`
+	_, err := client.Pipelined(ctx, func(pipe redisV8.Pipeliner) error {
+		if err := l.set(ctx, pipe, key, "A", a); err != nil {
+			return err
+		}
+		return l.set(ctx, pipe, key, "TXT", `[{"ttl":30,"text":"hello"}]`)
+	})
+	if err != nil && err != redisV8.Nil {
+		t.Fatal(err)
+	}
+	if err := l.set(ctx, client.Pipeline(), key, "MX", "30 IN MX 10 mail"); err != ErrNotJSON {
+		t.Errorf("expected ErrNotJSON for zone file text, got %v", err)
+	}
+
+	if val, err := l.get(ctx, client, key, "A"); err != nil || val != a {
+		t.Errorf("expected %s, got %s (%v)", a, val, err)
+	}
+	if _, err := l.get(ctx, client, key, "AAAA"); err != redisV8.Nil {
+		t.Errorf("expected redis.Nil, got %v", err)
+	}
+	if fields, err := l.fields(ctx, client, key); err != nil || len(fields) != 2 || fields["A"] != a {
+		t.Errorf("expected A and TXT, got %v (%v)", fields, err)
+	}
+	if fields, err := l.fields(ctx, client, "coredns:net:example:nx"); err != nil || len(fields) != 0 {
+		t.Errorf("expected no fields, got %v (%v)", fields, err)
+	}
+
+	var walked []string
+	mr.Set("coredns:net:example:string", "not a document")
+	err = l.walk(ctx, "example.net.", func(key string, fields map[string]string) error {
+		walked = append(walked, key)
+		return nil
+	})
+	if err != nil || len(walked) != 1 || walked[0] != key {
+		t.Errorf("expected %s, got %v (%v)", key, walked, err)
+	}
+
+	if _, err := client.Pipelined(ctx, func(pipe redisV8.Pipeliner) error {
+		l.del(ctx, pipe, key, "A")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if fields, _ := l.fields(ctx, client, key); len(fields) != 1 {
+		t.Errorf("expected TXT only, got %v", fields)
+	}
+}
+
+// fakeJSON registers the RedisJSON commands the layout sends with the root
+// and top-level paths it uses, keeping documents as strings.
+func fakeJSON(t *testing.T, mr *miniredis.Miniredis) {
+	doc := func(key string) (map[string]json.RawMessage, bool) {
+		val, err := mr.Get(key)
+		if err != nil || !strings.HasPrefix(val, "{") {
+			return nil, false
+		}
+		var d map[string]json.RawMessage
+		json.Unmarshal([]byte(val), &d)
+		return d, true
+	}
+	put := func(key string, d map[string]json.RawMessage) {
+		b, _ := json.Marshal(d)
+		mr.Set(key, string(b))
+	}
+
+	register := func(cmd string, f server.Cmd) {
+		if err := mr.Server().Register(cmd, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	register("JSON.SET", func(c *server.Peer, cmd string, args []string) {
+		d, ok := doc(args[0])
+		switch {
+		case args[1] == "$" && ok:
+			c.WriteNull()
+		case args[1] == "$":
+			put(args[0], map[string]json.RawMessage{})
+			c.WriteOK()
+		case ok:
+			d[strings.TrimPrefix(args[1], "$.")] = json.RawMessage(args[2])
+			put(args[0], d)
+			c.WriteOK()
+		default:
+			c.WriteError("ERR new objects must be created at the root")
+		}
+	})
+	register("JSON.GET", func(c *server.Peer, cmd string, args []string) {
+		d, ok := doc(args[0])
+		switch {
+		case !ok:
+			c.WriteNull()
+		case len(args) == 1:
+			b, _ := json.Marshal(d)
+			c.WriteBulk(string(b))
+		case d[strings.TrimPrefix(args[1], "$.")] != nil:
+			c.WriteBulk("[" + string(d[strings.TrimPrefix(args[1], "$.")]) + "]")
+		default:
+			c.WriteBulk("[]")
+		}
+	})
+	register("JSON.DEL", func(c *server.Peer, cmd string, args []string) {
+		d, ok := doc(args[0])
+		field := strings.TrimPrefix(args[1], "$.")
+		if !ok || d[field] == nil {
+			c.WriteInt(0)
+			return
+		}
+		delete(d, field)
+		put(args[0], d)
+		c.WriteInt(1)
+	})
+}
+
+// sameItems reports whether two JSON arrays hold the same items in any
+// order, as sets return them.
+func sameItems(a, b string) bool {
+	var ia, ib []json.RawMessage
+	if json.Unmarshal([]byte(a), &ia) != nil || json.Unmarshal([]byte(b), &ib) != nil || len(ia) != len(ib) {
+		return false
+	}
+	seen := make(map[string]int)
+	for _, item := range ia {
+		seen[string(item)]++
+	}
+	for _, item := range ib {
+		if seen[string(item)]--; seen[string(item)] < 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	redisV8 "github.com/go-redis/redis/v8"
+	"github.com/kexirong/coredns-redis/keys"
+)
+
+// InternalKey returns the key of plugin maintained data, kept apart from the
+// zone data by a leading underscore label.
+func InternalKey(prefix, kind string, parts ...string) string {
+	labels := []string{"_" + kind}
+	if prefix != "" {
+		labels = append([]string{prefix}, labels...)
+	}
+	return strings.Join(append(labels, parts...), keys.Separator)
+}
+
+// IsInternalKey reports whether key is one of InternalKey.
+func IsInternalKey(key, prefix string) bool {
+	if prefix != "" {
+		key = strings.TrimPrefix(key, prefix+keys.Separator)
+	}
+	return strings.HasPrefix(key, "_")
+}
+
+// AnyKey returns the key of the wildcard sibling of key.
+func AnyKey(key string) string {
+	parts := strings.Split(key, keys.Separator)
+	parts[len(parts)-1] = "*"
+	return strings.Join(parts, keys.Separator)
+}
+
+func IsAnyKey(key string) bool {
+	return key == "*" || strings.HasSuffix(key, keys.Separator+"*")
+}
+
+// scanKeys calls fn for every key matching pattern, on every master of a
+// cluster. Calls to fn are serialized.
+func scanKeys(ctx context.Context, client redisV8.UniversalClient, pattern string, fn func(key string) error) error {
+	var mu sync.Mutex
+	scan := func(ctx context.Context, client redisV8.Cmdable) error {
+		iter := client.Scan(ctx, 0, pattern, 1000).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			err := fn(iter.Val())
+			mu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+		return iter.Err()
+	}
+
+	if cluster, ok := client.(*redisV8.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, client *redisV8.Client) error {
+			return scan(ctx, client)
+		})
+	}
+	return scan(ctx, client)
+}
+
+// globEscape escapes the special characters of MATCH patterns in s.
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// Split255 splits a string into 255 byte chunks.
+func Split255(s string) []string {
+	if len(s) < 255 {
+		return []string{s}
+	}
+	sx := []string{}
+	p, i := 0, 255
+	for {
+		if i <= len(s) {
+			sx = append(sx, s[p:i])
+		} else {
+			sx = append(sx, s[p:])
+			break
+		}
+		p, i = p+255, i+255
+	}
+
+	return sx
+}
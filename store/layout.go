@@ -0,0 +1,270 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	redisV8 "github.com/go-redis/redis/v8"
+	"github.com/kexirong/coredns-redis/keys"
+)
+
+const (
+	LayoutNameHash = "name_hash"
+	LayoutZoneHash = "zone_hash"
+)
+
+// layout decides where the records of a key are kept in redis. Keys are
+// always built by Key, a layout maps them to its own structure. Reads go to
+// c, the client or a transaction; writes are queued on pipe.
+type layout interface {
+	// get returns field of key, or redisV8.Nil.
+	get(ctx context.Context, c redisV8.Cmdable, key, field string) (string, error)
+	// fields returns all fields of key.
+	fields(ctx context.Context, c redisV8.Cmdable, key string) (map[string]string, error)
+	// set stores field of key.
+	set(ctx context.Context, pipe redisV8.Pipeliner, key, field, val string) error
+	// del removes field of key.
+	del(ctx context.Context, pipe redisV8.Pipeliner, key, field string)
+	// walk calls fn with the key and fields of every name below zone.
+	walk(ctx context.Context, zone string, fn func(key string, fields map[string]string) error) error
+	// keys returns the redis keys holding the fields of key.
+	keys(key string) []string
+	// owner returns the key whose fields redisKey holds, "" for all keys of
+	// a zone.
+	owner(redisKey string) (key string, ok bool)
+}
+
+// nameHash is the layout of the README: one hash per name.
+type nameHash struct {
+	client redisV8.UniversalClient
+	prefix string
+}
+
+func (l nameHash) get(ctx context.Context, c redisV8.Cmdable, key, field string) (string, error) {
+	return c.HGet(ctx, key, field).Result()
+}
+
+func (l nameHash) fields(ctx context.Context, c redisV8.Cmdable, key string) (map[string]string, error) {
+	return c.HGetAll(ctx, key).Result()
+}
+
+func (l nameHash) set(ctx context.Context, pipe redisV8.Pipeliner, key, field, val string) error {
+	pipe.HSet(ctx, key, field, val)
+	return nil
+}
+
+func (l nameHash) del(ctx context.Context, pipe redisV8.Pipeliner, key, field string) {
+	pipe.HDel(ctx, key, field)
+}
+
+func (l nameHash) keys(key string) []string { return []string{key} }
+
+func (l nameHash) owner(redisKey string) (string, bool) {
+	return redisKey, !IsInternalKey(redisKey, l.prefix)
+}
+
+func (l nameHash) walk(ctx context.Context, zone string, fn func(key string, fields map[string]string) error) error {
+	zoneKey := keys.Key(zone, l.prefix)
+
+	visit := func(key string) error {
+		if IsInternalKey(key, l.prefix) {
+			return nil
+		}
+		fields, err := l.client.HGetAll(ctx, key).Result()
+		if err != nil || len(fields) == 0 {
+			// Not a hash, or gone in between.
+			return nil
+		}
+		return fn(key, fields)
+	}
+
+	match := zoneKey + keys.Separator + "*"
+	if zoneKey == "" {
+		match = "*"
+	}
+	if err := scanKeys(ctx, l.client, match, visit); err != nil {
+		return err
+	}
+	if zoneKey == "" {
+		return nil
+	}
+	return visit(zoneKey)
+}
+
+// zoneHash keeps a zone in a single hash, or a few sharded ones, with fields
+// named "relative-name/TYPE", "@" being the apex:
+//
+//	coredns:_zone:net:example    www/A, @/SOA, *.dev/CNAME, ...
+//
+// Sharded hashes get a "#N" suffix.
+type zoneHash struct {
+	client redisV8.UniversalClient
+	prefix string
+	zones  []string
+	shards int
+}
+
+// ZoneHashKey returns the key of the hash of zone in the zone_hash layout,
+// or of its shard n when there are several.
+func ZoneHashKey(zone, prefix string, shards, n int) string {
+	key := InternalKey(prefix, "zone")
+	if zoneKey := keys.Key(zone, ""); zoneKey != "" {
+		key += keys.Separator + zoneKey
+	}
+	if shards > 1 {
+		key += "#" + strconv.Itoa(n)
+	}
+	return key
+}
+
+// ZoneHashField returns the field of the type rrtype of name, which must be
+// in zone, in the hash of zone in the zone_hash layout, and the shard it
+// belongs to.
+func ZoneHashField(name, zone, rrtype string, shards int) (string, int) {
+	return zoneHashField(strings.TrimPrefix(strings.TrimPrefix(keys.Key(name, ""), keys.Key(zone, "")), keys.Separator), rrtype, shards)
+}
+
+func zoneHashField(relKey, rrtype string, shards int) (string, int) {
+	rel := "@"
+	if relKey != "" {
+		parts := strings.Split(relKey, keys.Separator)
+		for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+			parts[i], parts[j] = parts[j], parts[i]
+		}
+		// Labels are encoded, '.' can't appear in them.
+		rel = strings.Join(parts, ".")
+	}
+
+	n := 0
+	if shards > 1 {
+		h := fnv.New32a()
+		h.Write([]byte(rel))
+		n = int(h.Sum32() % uint32(shards))
+	}
+	return rel + "/" + rrtype, n
+}
+
+// zone returns the most specific zone of key and the key relative to it.
+func (l zoneHash) zone(key string) (zone, relKey string, ok bool) {
+	best := -1
+	for _, z := range l.zones {
+		zoneKey := keys.Key(z, l.prefix)
+		rest := key
+		if zoneKey != "" {
+			if key != zoneKey && !strings.HasPrefix(key, zoneKey+keys.Separator) {
+				continue
+			}
+			rest = strings.TrimPrefix(strings.TrimPrefix(key, zoneKey), keys.Separator)
+		}
+		if len(zoneKey) > best {
+			best, zone, relKey, ok = len(zoneKey), z, rest, true
+		}
+	}
+	return
+}
+
+func (l zoneHash) get(ctx context.Context, c redisV8.Cmdable, key, field string) (string, error) {
+	zone, relKey, ok := l.zone(key)
+	if !ok {
+		return "", redisV8.Nil
+	}
+	f, n := zoneHashField(relKey, field, l.shards)
+	return c.HGet(ctx, ZoneHashKey(zone, l.prefix, l.shards, n), f).Result()
+}
+
+func (l zoneHash) fields(ctx context.Context, c redisV8.Cmdable, key string) (map[string]string, error) {
+	fields := make(map[string]string)
+	zone, relKey, ok := l.zone(key)
+	if !ok {
+		return fields, nil
+	}
+	f, n := zoneHashField(relKey, "", l.shards)
+	iter := c.HScan(ctx, ZoneHashKey(zone, l.prefix, l.shards, n), 0, globEscape(f)+"*", 1000).Iterator()
+	for iter.Next(ctx) {
+		field := strings.TrimPrefix(iter.Val(), f)
+		if !iter.Next(ctx) {
+			break
+		}
+		fields[field] = iter.Val()
+	}
+	return fields, iter.Err()
+}
+
+func (l zoneHash) set(ctx context.Context, pipe redisV8.Pipeliner, key, field, val string) error {
+	zone, relKey, ok := l.zone(key)
+	if !ok {
+		return errors.New("key outside of the zones: " + key)
+	}
+	f, n := zoneHashField(relKey, field, l.shards)
+	pipe.HSet(ctx, ZoneHashKey(zone, l.prefix, l.shards, n), f, val)
+	return nil
+}
+
+func (l zoneHash) del(ctx context.Context, pipe redisV8.Pipeliner, key, field string) {
+	if zone, relKey, ok := l.zone(key); ok {
+		f, n := zoneHashField(relKey, field, l.shards)
+		pipe.HDel(ctx, ZoneHashKey(zone, l.prefix, l.shards, n), f)
+	}
+}
+
+func (l zoneHash) keys(key string) []string {
+	zone, relKey, ok := l.zone(key)
+	if !ok {
+		return nil
+	}
+	_, n := zoneHashField(relKey, "", l.shards)
+	return []string{ZoneHashKey(zone, l.prefix, l.shards, n)}
+}
+
+func (l zoneHash) owner(redisKey string) (string, bool) {
+	return "", strings.HasPrefix(redisKey, InternalKey(l.prefix, "zone"))
+}
+
+func (l zoneHash) walk(ctx context.Context, zone string, fn func(key string, fields map[string]string) error) error {
+	zoneKey := keys.Key(zone, l.prefix)
+	names := make(map[string]map[string]string)
+
+	for n := 0; n < l.shards || n == 0; n++ {
+		iter := l.client.HScan(ctx, ZoneHashKey(zone, l.prefix, l.shards, n), 0, "", 1000).Iterator()
+		for iter.Next(ctx) {
+			f := iter.Val()
+			if !iter.Next(ctx) {
+				break
+			}
+			i := strings.LastIndex(f, "/")
+			if i < 0 {
+				continue
+			}
+
+			key := zoneKey
+			if rel := f[:i]; rel != "@" {
+				parts := strings.Split(rel, ".")
+				for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+					parts[i], parts[j] = parts[j], parts[i]
+				}
+				if key != "" {
+					key += keys.Separator
+				}
+				key += strings.Join(parts, keys.Separator)
+			}
+
+			if names[key] == nil {
+				names[key] = make(map[string]string)
+			}
+			names[key][f[i+1:]] = iter.Val()
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+	}
+
+	for key, fields := range names {
+		if err := fn(key, fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package store
+
+import "testing"
+
+func TestZoneHashField(t *testing.T) {
+	tests := []struct {
+		name, zone, field string
+	}{
+		{"example.net.", "example.net.", "@/A"},
+		{"www.example.net.", "example.net.", "www/A"},
+		{"*.dev.example.net.", "example.net.", "*.dev/A"},
+		{"a:b.example.net.", "example.net.", "a%3Ab/A"},
+		{"www.example.net.", ".", "www.example.net/A"},
+	}
+	for _, tc := range tests {
+		if field, _ := ZoneHashField(tc.name, tc.zone, "A", 1); field != tc.field {
+			t.Errorf("ZoneHashField(%q, %q): expected %q, got %q", tc.name, tc.zone, tc.field, field)
+		}
+	}
+
+	if key := ZoneHashKey("example.net.", "coredns", 4, 3); key != "coredns:_zone:net:example#3" {
+		t.Errorf("expected coredns:_zone:net:example#3, got %q", key)
+	}
+
+	l := zoneHash{prefix: "coredns", zones: []string{"net.", "example.net."}}
+	if zone, rel, _ := l.zone("coredns:net:example:www"); zone != "example.net." || rel != "www" {
+		t.Errorf("expected example.net. and www, got %q and %q", zone, rel)
+	}
+}
+
+func TestZoneHashEvent(t *testing.T) {
+	s, err := New(nil, "coredns", Options{Layout: LayoutZoneHash, Zones: []string{"example.net."}, Shards: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	zoneKey := s.Key("example.net.")
+
+	// The field is not in the notification, any change reads the whole zone.
+	for _, key := range []string{"coredns:_zone:net:example#0", "coredns:_zone:net:example#3"} {
+		if ev, ok := s.event("__keyspace@0__:"+key, "example.net.", zoneKey); !ok || ev != (Event{Zone: "example.net."}) {
+			t.Errorf("%s: expected the whole zone, got %+v (%v)", key, ev, ok)
+		}
+	}
+	if ev, ok := s.event("__keyspace@0__:coredns:_zone:org:example#0", "example.net.", zoneKey); ok {
+		t.Errorf("expected no event for another zone, got %+v", ev)
+	}
+}
@@ -0,0 +1,231 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ItemRecords returns the RRs of the JSON items of field, owned by name. It
+// errs for the types without JSON items.
+func ItemRecords(field, val, name string) ([]dns.RR, error) {
+	var records []dns.RR
+	switch field {
+	case "A", "AAAA":
+		var items []ItemIP
+		if err := json.Unmarshal([]byte(val), &items); err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if field == "A" {
+				records = append(records, item.NewA(name))
+			} else {
+				records = append(records, item.NewAAAA(name))
+			}
+		}
+	case "TXT":
+		var items RecordTXT
+		if err := json.Unmarshal([]byte(val), &items); err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			records = append(records, item.NewTXT(name))
+		}
+	case "CNAME", "NS", "PTR":
+		var items []ItemHost
+		if err := json.Unmarshal([]byte(val), &items); err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			switch field {
+			case "CNAME":
+				records = append(records, item.NewCNAME(name))
+			case "NS":
+				records = append(records, item.NewNS(name))
+			default:
+				records = append(records, item.NewPTR(name))
+			}
+		}
+	case "MX":
+		var items RecordMX
+		if err := json.Unmarshal([]byte(val), &items); err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			records = append(records, item.NewMX(name))
+		}
+	case "SRV":
+		var items RecordSRV
+		if err := json.Unmarshal([]byte(val), &items); err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			records = append(records, item.NewSRV(name))
+		}
+	case "CAA":
+		var items RecordCAA
+		if err := json.Unmarshal([]byte(val), &items); err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			records = append(records, item.NewCAA(name))
+		}
+	case "SOA":
+		var item ItemSOA
+		if err := json.Unmarshal([]byte(val), &item); err != nil {
+			return nil, err
+		}
+		records = append(records, item.NewSOA(name))
+	default:
+		return nil, errors.New("no JSON items for " + field)
+	}
+	return records, nil
+}
+
+// DecodeValue returns the RRs of a field value in any encoding, owned by
+// name. Relative names in zone file text are relative to zone.
+func DecodeValue(field, val, name, zone string) ([]dns.RR, error) {
+	if IsJSON(val) {
+		return ItemRecords(field, val, name)
+	}
+	rrtype, ok := dns.StringToType[field]
+	if !ok {
+		return nil, errors.New("unknown type " + field)
+	}
+	return DecodeRaw(val, name, zone, rrtype)
+}
+
+// EncodeRecords returns the field value of rrs, which must all be of the same
+// type. With the json encoding, the types without JSON items are encoded as
+// zone file text.
+func EncodeRecords(rrs []dns.RR, encoding string) (string, error) {
+	if encoding == EncodingWire {
+		return PackWire(rrs)
+	}
+	if len(rrs) == 0 {
+		return "[]", nil
+	}
+
+	var items interface{}
+	switch rrs[0].(type) {
+	case *dns.A, *dns.AAAA:
+		var ips []ItemIP
+		for _, rr := range rrs {
+			switch rr := rr.(type) {
+			case *dns.A:
+				ips = append(ips, ItemIP{TTL: rr.Hdr.Ttl, IP: rr.A})
+			case *dns.AAAA:
+				ips = append(ips, ItemIP{TTL: rr.Hdr.Ttl, IP: rr.AAAA})
+			}
+		}
+		items = ips
+	case *dns.TXT:
+		var txts RecordTXT
+		for _, rr := range rrs {
+			text := strings.Join(rr.(*dns.TXT).Txt, "")
+			// Items are split every 255 bytes, other strings, as of DKIM
+			// keys split by hand, are kept in zone file text.
+			if !sameStrings(rr.(*dns.TXT).Txt, Split255(text)) {
+				return textValue(rrs), nil
+			}
+			txts = append(txts, ItemText{TTL: rr.Header().Ttl, Text: text})
+		}
+		items = txts
+	case *dns.CNAME, *dns.NS, *dns.PTR:
+		var hosts []ItemHost
+		for _, rr := range rrs {
+			var host string
+			switch rr := rr.(type) {
+			case *dns.CNAME:
+				host = rr.Target
+			case *dns.NS:
+				host = rr.Ns
+			case *dns.PTR:
+				host = rr.Ptr
+			}
+			hosts = append(hosts, ItemHost{TTL: rr.Header().Ttl, Host: host})
+		}
+		items = hosts
+	case *dns.MX:
+		var mxs RecordMX
+		for _, rr := range rrs {
+			mx := rr.(*dns.MX)
+			mxs = append(mxs, ItemMX{ItemHost: ItemHost{TTL: mx.Hdr.Ttl, Host: mx.Mx}, Preference: mx.Preference})
+		}
+		items = mxs
+	case *dns.SRV:
+		var srvs RecordSRV
+		for _, rr := range rrs {
+			srv := rr.(*dns.SRV)
+			srvs = append(srvs, ItemSRV{TTL: srv.Hdr.Ttl, Priority: srv.Priority, Weight: srv.Weight, Port: srv.Port, Target: srv.Target})
+		}
+		items = srvs
+	case *dns.CAA:
+		var caas RecordCAA
+		for _, rr := range rrs {
+			caa := rr.(*dns.CAA)
+			caas = append(caas, ItemCAA{TTL: caa.Hdr.Ttl, Flag: caa.Flag, Tag: caa.Tag, Value: caa.Value})
+		}
+		items = caas
+	case *dns.SOA:
+		soa := rrs[0].(*dns.SOA)
+		items = ItemSOA{NS: soa.Ns, Mbox: soa.Mbox, Serial: soa.Serial, Refresh: soa.Refresh, Retry: soa.Retry, Expire: soa.Expire, MinTTL: soa.Minttl}
+	default:
+		return textValue(rrs), nil
+	}
+
+	val, err := json.Marshal(items)
+	return string(val), err
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// NextSerial returns the SOA serial following serial, at least the current
+// time in seconds, as the plugin uses when none is stored.
+func NextSerial(serial uint32) uint32 {
+	now := uint32(time.Now().Unix())
+	if serial+1 < now {
+		return now
+	}
+	return serial + 1
+}
+
+// bumpSerial returns the SOA value val of zone with the next serial, in the
+// same encoding.
+func bumpSerial(val, zone string) (string, error) {
+	if IsJSON(val) {
+		var item ItemSOA
+		if err := json.Unmarshal([]byte(val), &item); err != nil {
+			return "", err
+		}
+		item.Serial = NextSerial(item.Serial)
+		b, err := json.Marshal(item)
+		return string(b), err
+	}
+
+	rrs, err := DecodeRaw(val, zone, zone, dns.TypeSOA)
+	if err != nil {
+		return "", err
+	}
+	if len(rrs) == 0 {
+		return "", errors.New("no SOA record")
+	}
+	rrs[0].(*dns.SOA).Serial = NextSerial(rrs[0].(*dns.SOA).Serial)
+	if IsWire(val) {
+		return PackWire(rrs[:1])
+	}
+	return textValue(rrs[:1]), nil
+}
@@ -0,0 +1,395 @@
+// Package store reads and writes the records served by the redis plugin. The
+// plugin reads through it, and so should every tool writing records, so that
+// readers and writers agree on keys, layouts and the JSON of the items.
+//
+//	s, err := store.New(client, "coredns", store.Options{})
+//	err = s.UpsertRRset(ctx, "example.net.", "www.example.net.", []dns.RR{a1, a2})
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	redisV8 "github.com/go-redis/redis/v8"
+	"github.com/kexirong/coredns-redis/keys"
+	"github.com/miekg/dns"
+)
+
+// ErrNotFound is returned for names and fields that don't exist.
+var ErrNotFound = errors.New("key not found")
+
+// maxTxRetries bounds the retries of a write racing with others.
+const maxTxRetries = 10
+
+// Options select how records are kept, as the options of the plugin do.
+type Options struct {
+	// Layout is LayoutNameHash (the default) or LayoutZoneHash.
+	Layout string
+	// Shards is the number of hashes per zone of LayoutZoneHash.
+	Shards int
+	// Zones are the zones of LayoutZoneHash.
+	Zones []string
+	// Format is FormatJSON (the default), FormatSet or FormatReJSON.
+	Format string
+	// Encoding is the encoding of the values written by UpsertRRset,
+	// EncodingJSON (the default) or EncodingWire.
+	Encoding string
+}
+
+// Store reads and writes the records of zones under a key prefix.
+type Store struct {
+	client   redisV8.UniversalClient
+	prefix   string
+	encoding string
+	layout   layout
+}
+
+// New returns a Store of the records under prefix.
+func New(client redisV8.UniversalClient, prefix string, opts Options) (*Store, error) {
+	if opts.Layout == "" {
+		opts.Layout = LayoutNameHash
+	}
+	if opts.Format == "" {
+		opts.Format = FormatJSON
+	}
+	if opts.Encoding == "" {
+		opts.Encoding = EncodingJSON
+	}
+	if opts.Shards < 1 {
+		opts.Shards = 1
+	}
+
+	s := &Store{client: client, prefix: prefix, encoding: opts.Encoding}
+	switch {
+	case opts.Encoding != EncodingJSON && opts.Encoding != EncodingWire:
+		return nil, fmt.Errorf("unknown encoding '%s'", opts.Encoding)
+	case opts.Encoding == EncodingWire && opts.Format != FormatJSON:
+		return nil, fmt.Errorf("encoding %s requires format %s", EncodingWire, FormatJSON)
+	case opts.Layout == LayoutZoneHash && opts.Format != FormatJSON:
+		return nil, fmt.Errorf("layout %s requires format %s", LayoutZoneHash, FormatJSON)
+	case opts.Layout == LayoutZoneHash:
+		s.layout = zoneHash{client: client, prefix: prefix, zones: opts.Zones, shards: opts.Shards}
+	case opts.Layout != LayoutNameHash:
+		return nil, fmt.Errorf("unknown layout '%s'", opts.Layout)
+	case opts.Format == FormatSet:
+		s.layout = nameSet{client: client, prefix: prefix}
+	case opts.Format == FormatReJSON:
+		s.layout = nameJSON{client: client, prefix: prefix}
+	case opts.Format == FormatJSON:
+		s.layout = nameHash{client: client, prefix: prefix}
+	default:
+		return nil, fmt.Errorf("unknown format '%s'", opts.Format)
+	}
+	return s, nil
+}
+
+// Client returns the redis client of s.
+func (s *Store) Client() redisV8.UniversalClient { return s.client }
+
+// Prefix returns the key prefix of s.
+func (s *Store) Prefix() string { return s.prefix }
+
+// Encoding returns the encoding of the values s writes.
+func (s *Store) Encoding() string { return s.encoding }
+
+// Key returns the key of name.
+func (s *Store) Key(name string) string { return keys.Key(name, s.prefix) }
+
+// Get returns field of key, or ErrNotFound. An empty field reads whether key
+// has any. Internal keys, such as the indexes of the plugin, are plain hashes
+// whatever the layout.
+func (s *Store) Get(ctx context.Context, key, field string) (string, error) {
+	var (
+		val string
+		err error
+	)
+	if field == "" {
+		fields, err := s.layout.fields(ctx, s.client, key)
+		if err == nil && len(fields) == 0 {
+			err = ErrNotFound
+		}
+		return "", err
+	}
+	if IsInternalKey(key, s.prefix) {
+		val, err = s.client.HGet(ctx, key, field).Result()
+	} else {
+		val, err = s.layout.get(ctx, s.client, key, field)
+	}
+	if err == redisV8.Nil {
+		return "", ErrNotFound
+	}
+	return val, err
+}
+
+// Fields returns all fields of key.
+func (s *Store) Fields(ctx context.Context, key string) (map[string]string, error) {
+	return s.layout.fields(ctx, s.client, key)
+}
+
+// Walk calls fn with the key and fields of every name below zone.
+func (s *Store) Walk(ctx context.Context, zone string, fn func(key string, fields map[string]string) error) error {
+	return s.layout.walk(ctx, dns.Fqdn(zone), fn)
+}
+
+// ReadZone returns the fields of every name below zone, by key.
+func (s *Store) ReadZone(ctx context.Context, zone string) (map[string]map[string]string, error) {
+	names := make(map[string]map[string]string)
+	err := s.Walk(ctx, zone, func(key string, fields map[string]string) error {
+		names[key] = fields
+		return nil
+	})
+	return names, err
+}
+
+// ListZone returns the records of every name below zone, the SOA first.
+// Items outside of their window are included.
+func (s *Store) ListZone(ctx context.Context, zone string) ([]dns.RR, error) {
+	zone = dns.Fqdn(zone)
+	var rrs []dns.RR
+	err := s.Walk(ctx, zone, func(key string, fields map[string]string) error {
+		name := keys.Name(key, s.prefix)
+		for field, val := range fields {
+			if field == FieldFailover {
+				continue
+			}
+			records, err := DecodeValue(field, val, name, zone)
+			if err != nil {
+				return fmt.Errorf("%s %s: %s", name, field, err)
+			}
+			rrs = append(rrs, records...)
+		}
+		return nil
+	})
+
+	sort.SliceStable(rrs, func(i, j int) bool {
+		hi, hj := rrs[i].Header(), rrs[j].Header()
+		if (hi.Rrtype == dns.TypeSOA) != (hj.Rrtype == dns.TypeSOA) {
+			return hi.Rrtype == dns.TypeSOA
+		}
+		if hi.Name != hj.Name {
+			return keys.Key(hi.Name, "") < keys.Key(hj.Name, "")
+		}
+		return hi.Rrtype < hj.Rrtype
+	})
+	return rrs, err
+}
+
+// UpsertRRset replaces the records of the type of rrs of name in zone by rrs,
+// which must all be of the same type and owned by name.
+func (s *Store) UpsertRRset(ctx context.Context, zone, name string, rrs []dns.RR) error {
+	if len(rrs) == 0 {
+		return errors.New("no records")
+	}
+	rrtype := rrs[0].Header().Rrtype
+	for _, rr := range rrs {
+		if rr.Header().Rrtype != rrtype || !dns.IsSubDomain(name, rr.Header().Name) || !dns.IsSubDomain(rr.Header().Name, name) {
+			return fmt.Errorf("%s is not a %s record of %s", rr, dns.Type(rrtype), name)
+		}
+	}
+
+	val, err := EncodeRecords(rrs, s.encoding)
+	if err != nil {
+		return err
+	}
+	return s.SetField(ctx, zone, name, dns.Type(rrtype).String(), val)
+}
+
+// DeleteRRset deletes the records of type rrtype of name in zone, or all
+// records of name for dns.TypeANY.
+func (s *Store) DeleteRRset(ctx context.Context, zone, name string, rrtype uint16) error {
+	field := ""
+	if rrtype != dns.TypeANY {
+		field = dns.Type(rrtype).String()
+	}
+	return s.DeleteField(ctx, zone, name, field)
+}
+
+// SetField checks and stores the value val of field of name in zone, and
+// bumps the serial of the SOA of zone, in a single transaction. The SOA
+// itself is stored as is.
+func (s *Store) SetField(ctx context.Context, zone, name, field, val string) error {
+	zone, name = dns.Fqdn(zone), dns.Fqdn(name)
+	return s.update(ctx, zone, name, func(fields map[string]string) (map[string]string, error) {
+		if err := CheckRRset(zone, name, field, val, fields); err != nil {
+			return nil, err
+		}
+		return map[string]string{field: val}, nil
+	})
+}
+
+// DeleteField deletes field of name in zone, or all fields of name if field
+// is empty, and bumps the serial of the SOA of zone, in a single transaction.
+func (s *Store) DeleteField(ctx context.Context, zone, name, field string) error {
+	zone, name = dns.Fqdn(zone), dns.Fqdn(name)
+	return s.update(ctx, zone, name, func(fields map[string]string) (map[string]string, error) {
+		changes := make(map[string]string)
+		for f := range fields {
+			if field == "" || f == field {
+				changes[f] = ""
+			}
+		}
+		if len(changes) == 0 {
+			return nil, ErrNotFound
+		}
+		return changes, nil
+	})
+}
+
+// update applies the changes fn returns for the fields of name, deleting
+// those with an empty value, together with a serial bump. It watches the
+// keys of name and of the apex of zone and retries when they change in
+// between; on a cluster, they have to be in the same slot.
+func (s *Store) update(ctx context.Context, zone, name string, fn func(fields map[string]string) (map[string]string, error)) error {
+	if !dns.IsSubDomain(zone, name) {
+		return fmt.Errorf("%s is not in %s", name, zone)
+	}
+	key, zoneKey := s.Key(name), s.Key(zone)
+	watched := s.layout.keys(key)
+	if key != zoneKey {
+		watched = append(watched, s.layout.keys(zoneKey)...)
+	}
+
+	txf := func(tx *redisV8.Tx) error {
+		fields, err := s.layout.fields(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+		changes, err := fn(fields)
+		if err != nil {
+			return err
+		}
+
+		soa := ""
+		if _, ok := changes["SOA"]; !ok || key != zoneKey {
+			soa, err = s.layout.get(ctx, tx, zoneKey, "SOA")
+			switch err {
+			case nil:
+				if soa, err = bumpSerial(soa, zone); err != nil {
+					return fmt.Errorf("SOA of %s: %s", zone, err)
+				}
+			case redisV8.Nil:
+			default:
+				return err
+			}
+		}
+
+		cmds, err := tx.TxPipelined(ctx, func(pipe redisV8.Pipeliner) error {
+			for field, val := range changes {
+				if val == "" {
+					s.layout.del(ctx, pipe, key, field)
+				} else if err := s.layout.set(ctx, pipe, key, field, val); err != nil {
+					return err
+				}
+			}
+			if soa != "" {
+				return s.layout.set(ctx, pipe, zoneKey, "SOA", soa)
+			}
+			return nil
+		})
+		if err == redisV8.Nil {
+			// Nil replies, as of creating an existing document, aren't errors.
+			for _, cmd := range cmds {
+				if err := cmd.Err(); err != nil && err != redisV8.Nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return err
+	}
+
+	for i := 0; i < maxTxRetries; i++ {
+		err := s.client.Watch(ctx, txf, watched...)
+		if err != redisV8.TxFailedErr {
+			return err
+		}
+	}
+	return redisV8.TxFailedErr
+}
+
+// Event reports a change below Zone, of the name Name or, when it is empty,
+// of any name of Zone.
+type Event struct {
+	Zone string
+	Name string
+}
+
+// Watch reports the changes below zone until ctx is done, from keyspace
+// notifications, which redis has to be configured to send with
+// notify-keyspace-events, e.g. "KA". On a cluster, only the changes of the
+// node the subscription lands on are seen. After a reconnection, a change of
+// any name is reported.
+func (s *Store) Watch(ctx context.Context, zone string) (<-chan Event, error) {
+	zone = dns.Fqdn(zone)
+	sub := s.client.PSubscribe(ctx, "__keyspace@*__:"+globEscape(s.prefix)+"*")
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, err
+	}
+
+	zoneKey := s.Key(zone)
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		defer sub.Close()
+		ch := sub.ChannelWithSubscriptions(ctx, 100)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				var ev Event
+				switch msg := msg.(type) {
+				case *redisV8.Subscription:
+					// Subscribed again after a reconnection, changes in
+					// between were missed.
+					ev = Event{Zone: zone}
+				case *redisV8.Message:
+					if ev, ok = s.event(msg.Channel, zone, zoneKey); !ok {
+						continue
+					}
+				default:
+					continue
+				}
+				select {
+				case events <- ev:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// event returns the Event of the keyspace notification channel of a key.
+func (s *Store) event(channel, zone, zoneKey string) (Event, bool) {
+	i := strings.Index(channel, "__:")
+	if i < 0 {
+		return Event{}, false
+	}
+
+	redisKey := channel[i+3:]
+	key, ok := s.layout.owner(redisKey)
+	if !ok {
+		return Event{}, false
+	}
+	if key == "" {
+		// A zone_hash hash, which may hold the zone. Notifications don't
+		// carry the changed field, so the owner is unknown.
+		if redisKey != ZoneHashKey(zone, s.prefix, 1, 0) && !strings.HasPrefix(redisKey, ZoneHashKey(zone, s.prefix, 1, 0)+"#") {
+			return Event{}, false
+		}
+		return Event{Zone: zone}, true
+	}
+	if zoneKey != "" && key != zoneKey && !strings.HasPrefix(key, zoneKey+keys.Separator) {
+		return Event{}, false
+	}
+	return Event{Zone: zone, Name: keys.Name(key, s.prefix)}, true
+}
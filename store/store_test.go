@@ -0,0 +1,149 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redisV8 "github.com/go-redis/redis/v8"
+	"github.com/miekg/dns"
+)
+
+func TestStore(t *testing.T) {
+	client, mr := testClient(t)
+	s, err := New(client, "coredns", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	soa, _ := dns.NewRR("example.net. 30 IN SOA ns1.example.net. hostmaster.example.net. 2024010101 7200 1800 86400 30")
+	a1, _ := dns.NewRR("www.example.net. 30 IN A 192.0.2.1")
+	a2, _ := dns.NewRR("www.example.net. 30 IN A 192.0.2.2")
+	mx, _ := dns.NewRR("example.net. 300 IN MX 10 mail.example.net.")
+	for _, rrs := range [][]dns.RR{{soa}, {a1, a2}, {mx}} {
+		if err := s.UpsertRRset(ctx, "example.net.", rrs[0].Header().Name, rrs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.UpsertRRset(ctx, "example.net.", "www.example.net.", []dns.RR{a1, mx}); err == nil {
+		t.Error("expected an error for records of different types")
+	}
+	if err := s.UpsertRRset(ctx, "example.net.", "www.example.org.", []dns.RR{a1}); err == nil {
+		t.Error("expected an error for records of another name")
+	}
+	if val := mr.HGet("coredns:net:example:www", "A"); val != `[{"ttl":30,"ip":"192.0.2.1"},{"ttl":30,"ip":"192.0.2.2"}]` {
+		t.Errorf("expected the JSON items of www A, got %s", val)
+	}
+
+	rrs, err := s.ListZone(ctx, "example.net")
+	if err != nil || len(rrs) != 4 {
+		t.Fatalf("expected 4 records, got %v (%v)", rrs, err)
+	}
+	if rrs[0].Header().Rrtype != dns.TypeSOA || rrs[1].Header().Rrtype != dns.TypeMX || rrs[2].Header().Name != "www.example.net." {
+		t.Errorf("expected SOA, MX and the A records of www, got %v", rrs)
+	}
+	// The SOA itself is stored as is, every other write bumps its serial.
+	if serial := rrs[0].(*dns.SOA).Serial; serial <= 2024010101+1 {
+		t.Errorf("expected the serial bumped twice, got %d", serial)
+	}
+
+	if err := s.DeleteRRset(ctx, "example.net.", "www.example.net.", dns.TypeA); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteRRset(ctx, "example.net.", "www.example.net.", dns.TypeA); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if err := s.DeleteRRset(ctx, "example.net.", "example.net.", dns.TypeANY); err != nil {
+		t.Fatal(err)
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Errorf("expected no keys, got %v", keys)
+	}
+}
+
+// conflictHook writes the key the store reads, as another writer would,
+// right after the first n reads of it.
+type conflictHook struct {
+	mr  *miniredis.Miniredis
+	key string
+	n   *int
+}
+
+func (h conflictHook) BeforeProcess(ctx context.Context, cmd redisV8.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h conflictHook) AfterProcess(ctx context.Context, cmd redisV8.Cmder) error {
+	if cmd.Name() == "hgetall" && *h.n > 0 {
+		*h.n--
+		h.mr.HSet(h.key, "TXT", `[{"ttl":30,"text":"other"}]`)
+	}
+	return nil
+}
+
+func (conflictHook) BeforeProcessPipeline(ctx context.Context, cmds []redisV8.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (conflictHook) AfterProcessPipeline(ctx context.Context, cmds []redisV8.Cmder) error { return nil }
+
+func TestUpdateConflict(t *testing.T) {
+	client, mr := testClient(t)
+	s, err := New(client, "coredns", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conflicts := 1
+	client.AddHook(conflictHook{mr: mr, key: "coredns:net:example:www", n: &conflicts})
+
+	a := `[{"ttl":30,"ip":"192.0.2.1"}]`
+	if err := s.SetField(ctx, "example.net.", "www.example.net.", "A", a); err != nil {
+		t.Fatal(err)
+	}
+	if mr.HGet("coredns:net:example:www", "A") != a || mr.HGet("coredns:net:example:www", "TXT") == "" {
+		t.Error("expected the write retried after the conflicting one")
+	}
+
+	conflicts = maxTxRetries
+	if err := s.SetField(ctx, "example.net.", "www.example.net.", "A", a); err != redisV8.TxFailedErr {
+		t.Errorf("expected TxFailedErr, got %v", err)
+	}
+
+	if err := s.SetField(ctx, "example.net.", "www.example.org.", "A", a); err == nil {
+		t.Error("expected an error for a name outside of the zone")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	client, _ := testClient(t)
+	s, err := New(client, "coredns", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events, err := s.Watch(ctx, "example.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"coredns:org:example:www", "coredns:_auto_ptr", "coredns:net:example:www", "coredns:net:example"} {
+		client.Publish(ctx, "__keyspace@0__:"+key, "hset")
+	}
+
+	for _, name := range []string{"www.example.net.", "example.net."} {
+		select {
+		case ev := <-events:
+			if ev.Zone != "example.net." || ev.Name != name {
+				t.Errorf("expected %s, got %+v", name, ev)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no event for %s", name)
+		}
+	}
+
+	cancel()
+	for range events {
+	}
+}
@@ -0,0 +1,63 @@
+package store
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// IsJSON reports whether val holds JSON items rather than RRs in
+// presentation or wire format.
+func IsJSON(val string) bool {
+	s := strings.TrimSpace(val)
+	return strings.HasPrefix(s, "[") || strings.HasPrefix(s, "{")
+}
+
+// parseText parses val, RRs in presentation format without owner name, one
+// per line, such as "30 IN MX 10 mail.example.net.", and returns those of
+// type rrtype owned by name. Relative names are relative to zone, and a
+// missing TTL is that of the line before, or 3600.
+func parseText(val, name, zone string, rrtype uint16) ([]dns.RR, error) {
+	var b strings.Builder
+	for _, line := range strings.Split(val, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		b.WriteString(name)
+		b.WriteByte(' ')
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+
+	var records []dns.RR
+	zp := dns.NewZoneParser(strings.NewReader(b.String()), dns.Fqdn(zone), "")
+	zp.SetDefaultTTL(3600)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		if rr.Header().Rrtype == rrtype {
+			records = append(records, rr)
+		}
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// DecodeRaw decodes a value in wire or presentation format, returning the
+// RRs of type rrtype owned by name.
+func DecodeRaw(val, name, zone string, rrtype uint16) ([]dns.RR, error) {
+	if IsWire(val) {
+		return unpackWire(val, name, rrtype)
+	}
+	return parseText(val, name, zone, rrtype)
+}
+
+// textValue returns rrs as presentation format text without owner names.
+func textValue(rrs []dns.RR) string {
+	lines := make([]string, len(rrs))
+	for i, rr := range rrs {
+		s := rr.String()
+		lines[i] = s[strings.IndexByte(s, '\t')+1:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,66 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestIsJSON(t *testing.T) {
+	for val, want := range map[string]bool{
+		`[{"ttl":30,"ip":"192.0.2.1"}]`: true,
+		` {"ttl":30}`:                   true,
+		"30 IN MX 10 mail":              false,
+		"\x00W\x00":                     false,
+		"":                              false,
+	} {
+		if IsJSON(val) != want {
+			t.Errorf("IsJSON(%q): expected %t", val, want)
+		}
+	}
+}
+
+func TestParseText(t *testing.T) {
+	val := "30 IN MX 10 mail\n\n MX 20 mail2.example.org.\n60 IN TXT \"other\""
+	rrs, err := parseText(val, "example.net.", "example.net.", dns.TypeMX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rrs) != 2 {
+		t.Fatalf("expected 2 MX, got %v", rrs)
+	}
+	if mx := rrs[0].(*dns.MX); mx.Mx != "mail.example.net." || mx.Hdr.Ttl != 30 || mx.Hdr.Name != "example.net." {
+		t.Errorf("expected mail.example.net. with ttl 30, got %s", mx)
+	}
+	if mx := rrs[1].(*dns.MX); mx.Mx != "mail2.example.org." || mx.Hdr.Ttl != 30 {
+		t.Errorf("expected mail2.example.org. with the ttl of the line before, got %s", mx)
+	}
+	if rrs, _ := parseText("MX 10 mail", "example.net.", "example.net.", dns.TypeMX); len(rrs) != 1 || rrs[0].Header().Ttl != 3600 {
+		t.Errorf("expected a default ttl of 3600, got %v", rrs)
+	}
+
+	if _, err := parseText("30 IN MX mail", "example.net.", "example.net.", dns.TypeMX); err == nil {
+		t.Error("expected an error for a bad MX")
+	}
+	if rrs, err := parseText(textValue(rrs), "example.net.", "example.net.", dns.TypeMX); err != nil || len(rrs) != 2 {
+		t.Errorf("expected textValue to parse back, got %v (%v)", rrs, err)
+	}
+}
+
+func TestEncodeTXTStrings(t *testing.T) {
+	split, _ := dns.NewRR(`www.example.net. 30 IN TXT "v=DKIM1; k=rsa; " "p=MIGfMA0GCSqGSIb3DQEBAQUAA4GN"`)
+	single, _ := dns.NewRR(`www.example.net. 30 IN TXT "hello"`)
+
+	val, err := EncodeRecords([]dns.RR{split, single}, EncodingJSON)
+	if err != nil || IsJSON(val) {
+		t.Fatalf("expected zone file text for split strings, got %s (%v)", val, err)
+	}
+	rrs, err := DecodeValue("TXT", val, "www.example.net.", "example.net.")
+	if err != nil || len(rrs) != 2 || !dns.IsDuplicate(rrs[0], split) || !dns.IsDuplicate(rrs[1], single) {
+		t.Errorf("expected both TXT back with their strings, got %v (%v)", rrs, err)
+	}
+
+	if val, err := EncodeRecords([]dns.RR{single}, EncodingJSON); err != nil || val != `[{"ttl":30,"text":"hello"}]` {
+		t.Errorf("expected a JSON item, got %s (%v)", val, err)
+	}
+}
@@ -0,0 +1,193 @@
+package store
+
+import (
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+type RecordSOA ItemSOA
+type RecordA []ItemIP
+type RecordAAAA []ItemIP
+type RecordTXT []ItemText
+type RecordCNANE []ItemHost
+type RecordNS []ItemHost
+type RecordPTR []ItemHost
+type RecordMX []ItemMX
+type RecordSRV []ItemSRV
+type RecordCAA []ItemCAA
+
+// Window limits an item to the time between NotBefore and NotAfter, either
+// of which may be left out.
+type Window struct {
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	NotAfter  *time.Time `json:"not_after,omitempty"`
+}
+
+// Active reports whether the current time falls inside the window.
+func (w Window) Active() bool {
+	now := time.Now()
+	if w.NotBefore != nil && now.Before(*w.NotBefore) {
+		return false
+	}
+	return w.NotAfter == nil || now.Before(*w.NotAfter)
+}
+
+// ttl clamps ttl so that caches don't keep the item beyond NotAfter.
+func (w Window) ttl(ttl uint32) uint32 {
+	if w.NotAfter == nil {
+		return ttl
+	}
+	left := time.Until(*w.NotAfter) / time.Second
+	if left < 0 {
+		return 0
+	}
+	if uint64(left) < uint64(ttl) {
+		return uint32(left)
+	}
+	return ttl
+}
+
+type ItemIP struct {
+	Window
+
+	TTL   uint32     `json:"ttl,omitempty"`
+	IP    net.IP     `json:"ip"`
+	Check *ItemCheck `json:"check,omitempty"`
+}
+
+func (i ItemIP) NewA(name string) *dns.A {
+	return &dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: i.ttl(i.TTL)}, A: i.IP}
+}
+
+func (i ItemIP) NewAAAA(name string) *dns.AAAA {
+	return &dns.AAAA{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: i.ttl(i.TTL)}, AAAA: i.IP}
+}
+
+type ItemText struct {
+	Window
+
+	TTL  uint32 `json:"ttl,omitempty"`
+	Text string `json:"text"`
+}
+
+func (i ItemText) NewTXT(name string) *dns.TXT {
+	return &dns.TXT{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: i.ttl(i.TTL)}, Txt: Split255(i.Text)}
+}
+
+type ItemHost struct {
+	Window
+
+	TTL  uint32 `json:"ttl,omitempty"`
+	Host string `json:"host"`
+}
+
+func (i ItemHost) NewCNAME(name string) *dns.CNAME {
+	return &dns.CNAME{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: i.ttl(i.TTL)}, Target: dns.Fqdn(i.Host)}
+}
+
+func (i ItemHost) NewNS(name string) *dns.NS {
+	return &dns.NS{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: i.ttl(i.TTL)}, Ns: dns.Fqdn(i.Host)}
+}
+
+func (i ItemHost) NewPTR(name string) *dns.PTR {
+	return &dns.PTR{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: i.ttl(i.TTL)}, Ptr: dns.Fqdn(i.Host)}
+}
+
+type ItemMX struct {
+	ItemHost
+	Preference uint16 `json:"preference"`
+}
+
+func (i ItemMX) NewMX(name string) *dns.MX {
+	return &dns.MX{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeMX, Class: dns.ClassINET, Ttl: i.ttl(i.TTL)}, Mx: dns.Fqdn(i.Host), Preference: i.Preference}
+}
+
+type ItemSRV struct {
+	Window
+
+	TTL      uint32 `json:"ttl,omitempty"`
+	Priority uint16 `json:"priority"`
+	Weight   uint16 `json:"weight"`
+	Port     uint16 `json:"port"`
+	Target   string `json:"target"`
+
+	Check *ItemCheck `json:"check,omitempty"`
+}
+
+func (i ItemSRV) NewSRV(name string) *dns.SRV {
+	return &dns.SRV{
+		Hdr:      dns.RR_Header{Name: name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: i.ttl(i.TTL)},
+		Priority: i.Priority,
+		Port:     i.Port,
+		Weight:   i.Weight,
+		Target:   dns.Fqdn(i.Target),
+	}
+}
+
+type ItemSOA struct {
+	Window
+
+	NS      string `json:"ns"`
+	Mbox    string `json:"Mbox"`
+	Serial  uint32 `json:"serial,omitempty"`
+	Refresh uint32 `json:"refresh"`
+	Retry   uint32 `json:"retry"`
+	Expire  uint32 `json:"expire"`
+	MinTTL  uint32 `json:"minTTL"`
+}
+
+// NewSOA returns the SOA record of the item, with the current time as serial
+// unless the item has one.
+func (i ItemSOA) NewSOA(name string) *dns.SOA {
+	serial := i.Serial
+	if serial == 0 {
+		serial = uint32(time.Now().Unix())
+	}
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: name, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: i.ttl(i.MinTTL)},
+		Mbox:    dns.Fqdn(i.Mbox),
+		Ns:      dns.Fqdn(i.NS),
+		Serial:  serial,
+		Refresh: i.Refresh,
+		Retry:   i.Retry,
+		Expire:  i.Expire,
+		Minttl:  i.MinTTL,
+	}
+}
+
+type ItemCAA struct {
+	Window
+
+	TTL   uint32 `json:"ttl,omitempty"`
+	Flag  uint8  `json:"flag"`
+	Tag   string `json:"tag"`
+	Value string `json:"value"`
+}
+
+func (i ItemCAA) NewCAA(name string) *dns.CAA {
+	return &dns.CAA{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeCAA, Class: dns.ClassINET, Ttl: i.ttl(i.TTL)}, Flag: i.Flag, Tag: i.Tag, Value: i.Value}
+}
+
+// ItemCheck describes how the target of an ItemIP or ItemSRV is probed by
+// the health checks of the plugin.
+type ItemCheck struct {
+	Type    string `json:"type"`
+	Port    uint16 `json:"port,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Timeout uint32 `json:"timeout,omitempty"`
+}
+
+// FieldFailover is the field holding the ItemFailover of a name.
+const FieldFailover = "FAILOVER"
+
+// ItemFailover answers A and AAAA queries with Primary while at least one of
+// its items is healthy, and with Secondary or CNAME otherwise. A switch only
+// happens after the new state has been wanted for Hysteresis seconds.
+type ItemFailover struct {
+	Primary    []ItemIP  `json:"primary"`
+	Secondary  []ItemIP  `json:"secondary,omitempty"`
+	CNAME      *ItemHost `json:"cname,omitempty"`
+	Hysteresis uint32    `json:"hysteresis,omitempty"`
+}
@@ -0,0 +1,30 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindow(t *testing.T) {
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Minute)
+
+	tests := []struct {
+		w      Window
+		active bool
+		ttl    uint32
+	}{
+		{Window{}, true, 300},
+		{Window{NotBefore: &past}, true, 300},
+		{Window{NotBefore: &future}, false, 300},
+		{Window{NotAfter: &past}, false, 0},
+		{Window{NotBefore: &past, NotAfter: &future}, true, 59},
+	}
+	for i, tc := range tests {
+		if got := tc.w.Active(); got != tc.active {
+			t.Errorf("test %d: expected active %t, got %t", i, tc.active, got)
+		}
+		if got := tc.w.ttl(300); got != tc.ttl && got != tc.ttl+1 {
+			t.Errorf("test %d: expected ttl %d, got %d", i, tc.ttl, got)
+		}
+	}
+}
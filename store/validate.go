@@ -0,0 +1,302 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kexirong/coredns-redis/keys"
+	"github.com/miekg/dns"
+)
+
+// Problem is an issue with the data of a zone, found by Validate.
+type Problem struct {
+	Name  string
+	Field string
+	Msg   string
+}
+
+func (p Problem) String() string {
+	if p.Field == "" {
+		return p.Name + ": " + p.Msg
+	}
+	return p.Name + " " + p.Field + ": " + p.Msg
+}
+
+// Validate checks the data of zones and returns the problems found, such as
+// values that would make queries fail.
+func (s *Store) Validate(ctx context.Context, zones ...string) ([]Problem, error) {
+	var problems []Problem
+	for _, zone := range zones {
+		names, err := s.ReadZone(ctx, dns.Fqdn(zone))
+		if err != nil {
+			return problems, err
+		}
+		problems = append(problems, ValidateZone(dns.Fqdn(zone), s.prefix, names)...)
+	}
+	return problems, nil
+}
+
+// ValidateZone checks the fields of the names of zone, by key as returned by
+// Store.ReadZone.
+func ValidateZone(zone, prefix string, names map[string]map[string]string) []Problem {
+	var problems []Problem
+	report := func(name, field, format string, a ...interface{}) {
+		problems = append(problems, Problem{Name: name, Field: field, Msg: fmt.Sprintf(format, a...)})
+	}
+
+	apex := names[keys.Key(zone, prefix)]
+	if _, ok := apex["SOA"]; !ok {
+		report(zone, "", "no SOA at the apex")
+	}
+	if _, ok := apex["NS"]; !ok {
+		report(zone, "", "no NS at the apex")
+	}
+
+	for key, fields := range names {
+		name := keys.Name(key, prefix)
+
+		if _, ok := fields["CNAME"]; ok {
+			for field := range fields {
+				if field != "CNAME" && field != FieldFailover {
+					report(name, field, "CNAME and other data")
+				}
+			}
+		}
+
+		for field, val := range fields {
+			if field != FieldFailover {
+				if _, ok := dns.StringToType[field]; !ok {
+					report(name, field, "unknown type")
+					continue
+				}
+			}
+
+			if IsJSON(val) {
+				msgs := validateItems(field, val)
+				for _, msg := range msgs {
+					report(name, field, "%s", msg)
+				}
+				if len(msgs) > 0 {
+					continue
+				}
+			}
+			if field == FieldFailover {
+				continue
+			}
+
+			rrs, err := DecodeValue(field, val, name, zone)
+			if err != nil {
+				report(name, field, "%s", err)
+				continue
+			}
+			for _, rr := range rrs {
+				target := rrTarget(rr)
+				if target == "" || target == "." || !dns.IsSubDomain(zone, target) {
+					continue
+				}
+				targetKey := keys.Key(target, prefix)
+				if names[targetKey] == nil && names[AnyKey(targetKey)] == nil {
+					report(name, field, "target %s does not exist", target)
+				}
+			}
+		}
+	}
+
+	sort.Slice(problems, func(i, j int) bool {
+		if problems[i].Name != problems[j].Name {
+			return problems[i].Name < problems[j].Name
+		}
+		return problems[i].Field < problems[j].Field
+	})
+	return problems
+}
+
+// CheckRRset checks the value val of field of name in zone before it is
+// written, fields being the other fields of name.
+func CheckRRset(zone, name, field, val string, fields map[string]string) error {
+	if field != FieldFailover {
+		if _, ok := dns.StringToType[field]; !ok {
+			return fmt.Errorf("unknown type %s", field)
+		}
+	}
+	if !dns.IsSubDomain(zone, name) {
+		return fmt.Errorf("%s is not in %s", name, zone)
+	}
+	if field == "SOA" && !dns.IsSubDomain(name, zone) {
+		return fmt.Errorf("SOA of %s, not at the apex", name)
+	}
+
+	if IsJSON(val) {
+		if msgs := validateItems(field, val); len(msgs) > 0 {
+			return errors.New(strings.Join(msgs, "; "))
+		}
+	}
+	if field != FieldFailover {
+		rrs, err := DecodeValue(field, val, name, zone)
+		if err != nil {
+			return err
+		}
+		if len(rrs) == 0 {
+			return errors.New("no records")
+		}
+	}
+
+	for other := range fields {
+		if other == field || other == FieldFailover {
+			continue
+		}
+		if field == "CNAME" || other == "CNAME" {
+			return ErrConflict
+		}
+	}
+	return nil
+}
+
+// ErrConflict is returned for writes that would make a CNAME coexist with
+// other data.
+var ErrConflict = errors.New("CNAME and other data")
+
+// validateItems checks the JSON items of field, which Lookup would decode.
+func validateItems(field, val string) (msgs []string) {
+	var v interface{}
+	switch field {
+	case "A", "AAAA":
+		v = &RecordA{}
+	case "TXT":
+		v = &RecordTXT{}
+	case "CNAME", "NS", "PTR":
+		v = &RecordCNANE{}
+	case "MX":
+		v = &RecordMX{}
+	case "SRV":
+		v = &RecordSRV{}
+	case "CAA":
+		v = &RecordCAA{}
+	case "SOA":
+		v = &ItemSOA{}
+	case FieldFailover:
+		v = &ItemFailover{}
+	default:
+		return []string{"no JSON items for the type"}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader([]byte(val)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return []string{err.Error()}
+	}
+
+	checkIP := func(item ItemIP, family string) {
+		switch {
+		case item.IP == nil:
+			msgs = append(msgs, "item without ip")
+		case family == "A" && item.IP.To4() == nil:
+			msgs = append(msgs, fmt.Sprintf("%s is not an IPv4 address", item.IP))
+		case family == "AAAA" && item.IP.To4() != nil:
+			msgs = append(msgs, fmt.Sprintf("%s is not an IPv6 address", item.IP))
+		}
+	}
+	checkProbe := func(check *ItemCheck, port uint16) {
+		if check == nil {
+			return
+		}
+		switch check.Type {
+		case "", "tcp":
+			if check.Port == 0 && port == 0 {
+				msgs = append(msgs, "tcp check without port")
+			}
+		case "http":
+		default:
+			msgs = append(msgs, fmt.Sprintf("unknown check type %s", check.Type))
+		}
+	}
+	checkHost := func(item ItemHost) {
+		if item.Host == "" {
+			msgs = append(msgs, "item without host")
+		}
+	}
+
+	switch v := v.(type) {
+	case *RecordA:
+		for _, item := range *v {
+			checkIP(item, field)
+			checkProbe(item.Check, 0)
+		}
+	case *RecordTXT:
+		for _, item := range *v {
+			if len(item.Text) < 255 {
+				continue
+			}
+			// Long texts are split into strings of 255 bytes, which only
+			// matters where that breaks them.
+			chunks := Split255(item.Text)
+			var faults []string
+			for _, chunk := range chunks {
+				if !utf8.ValidString(chunk) && utf8.ValidString(item.Text) {
+					faults = append(faults, "inside a UTF-8 character")
+					break
+				}
+			}
+			if chunks[len(chunks)-1] == "" {
+				faults = append(faults, "the last one empty")
+			}
+			if len(faults) > 0 {
+				msgs = append(msgs, fmt.Sprintf("text of %d bytes is split into %d strings, %s", len(item.Text), len(chunks), strings.Join(faults, ", ")))
+			}
+		}
+	case *RecordCNANE:
+		for _, item := range *v {
+			checkHost(item)
+		}
+	case *RecordMX:
+		for _, item := range *v {
+			checkHost(item.ItemHost)
+		}
+	case *RecordSRV:
+		for _, item := range *v {
+			if item.Target == "" {
+				msgs = append(msgs, "item without target")
+			}
+			checkProbe(item.Check, item.Port)
+		}
+	case *ItemSOA:
+		if v.NS == "" || v.Mbox == "" {
+			msgs = append(msgs, "SOA without ns or Mbox")
+		}
+	case *ItemFailover:
+		if len(v.Primary) == 0 {
+			msgs = append(msgs, "failover without primary")
+		}
+		for _, item := range append(v.Primary, v.Secondary...) {
+			if item.IP == nil {
+				msgs = append(msgs, "item without ip")
+			}
+			checkProbe(item.Check, 0)
+		}
+		if v.CNAME != nil {
+			checkHost(*v.CNAME)
+		}
+	}
+	return msgs
+}
+
+// rrTarget returns the name rr points to within its zone, if any.
+func rrTarget(rr dns.RR) string {
+	switch rr := rr.(type) {
+	case *dns.CNAME:
+		return rr.Target
+	case *dns.NS:
+		return rr.Ns
+	case *dns.MX:
+		return rr.Mx
+	case *dns.SRV:
+		return rr.Target
+	}
+	return ""
+}
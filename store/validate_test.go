@@ -0,0 +1,48 @@
+package store
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateZone(t *testing.T) {
+	names := map[string]map[string]string{
+		"coredns:net:example": {
+			"SOA": `{"ns":"ns1.example.net.","Mbox":"hostmaster.example.net.","refresh":44,"retry":55,"expire":66,"minTTL":100}`,
+			"NS":  `[{"host":"ns1.example.net."}]`,
+		},
+		"coredns:net:example:ns1":  {"A": `[{"ip":"192.0.2.1"}]`},
+		"coredns:net:example:www":  {"A": `[{"ip":"192.0.2.1","tll":30}]`},
+		"coredns:net:example:ipv6": {"AAAA": `[{"ip":"192.0.2.1"}]`},
+		"coredns:net:example:bad":  {"A": `[{"ip":"192.0.2.300"}]`},
+		"coredns:net:example:mail": {"CNAME": `[{"host":"www.example.net."}]`, "TXT": `[{"text":"x"}]`},
+		"coredns:net:example:mx":   {"MX": `[{"host":"mail2.example.net.","preference":10}]`},
+		"coredns:net:example:long": {"TXT": `[{"text":"` + strings.Repeat("a", 255) + `"}]`},
+		"coredns:net:example:utf8": {"TXT": `[{"text":"` + strings.Repeat("a", 254) + `é"}]`},
+		// Long texts are fine, as long as splitting them breaks nothing.
+		"coredns:net:example:ascii": {"TXT": `[{"text":"` + strings.Repeat("a", 300) + `"}]`},
+		"coredns:net:example:text":  {"MX": "30 IN MX 10 ns1"},
+		"coredns:net:example:web":   {"A": `[{"ip":"192.0.2.1","check":{"type":"tcp"}}]`},
+	}
+
+	want := []string{
+		"bad.example.net. A: invalid IP address: 192.0.2.300",
+		"ipv6.example.net. AAAA: 192.0.2.1 is not an IPv6 address",
+		"long.example.net. TXT: text of 255 bytes is split into 2 strings, the last one empty",
+		"mail.example.net. TXT: CNAME and other data",
+		"mx.example.net. MX: target mail2.example.net. does not exist",
+		"utf8.example.net. TXT: text of 256 bytes is split into 2 strings, inside a UTF-8 character",
+		"web.example.net. A: tcp check without port",
+		`www.example.net. A: json: unknown field "tll"`,
+	}
+
+	problems := ValidateZone("example.net.", "coredns", names)
+	if len(problems) != len(want) {
+		t.Fatalf("expected %d problems, got %v", len(want), problems)
+	}
+	for i, p := range problems {
+		if p.String() != want[i] {
+			t.Errorf("expected %q, got %q", want[i], p)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"context"
+	"strings"
+
+	redisV8 "github.com/go-redis/redis/v8"
+	"github.com/kexirong/coredns-redis/keys"
+	"github.com/miekg/dns"
+)
+
+const (
+	EncodingJSON = "json"
+	EncodingWire = "wire"
+)
+
+// wirePrefix starts values holding RRs in wire format, which neither JSON nor
+// presentation format text can start with.
+const wirePrefix = "\x00W"
+
+func IsWire(val string) bool {
+	return strings.HasPrefix(val, wirePrefix)
+}
+
+// PackWire encodes rrs in wire format, as stored in a field. Owner names are
+// left out, they are set from the question when unpacking.
+func PackWire(rrs []dns.RR) (string, error) {
+	buf := []byte(wirePrefix)
+	for _, rr := range rrs {
+		rr = dns.Copy(rr)
+		rr.Header().Name = "."
+		b := make([]byte, dns.Len(rr))
+		off, err := dns.PackRR(rr, b, 0, nil, false)
+		if err != nil {
+			return "", err
+		}
+		buf = append(buf, b[:off]...)
+	}
+	return string(buf), nil
+}
+
+// unpackWire decodes a value packed by PackWire, returning the RRs of type
+// rrtype with name as owner.
+func unpackWire(val, name string, rrtype uint16) ([]dns.RR, error) {
+	msg := []byte(val[len(wirePrefix):])
+
+	var records []dns.RR
+	for off := 0; off < len(msg); {
+		rr, next, err := dns.UnpackRR(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next
+		if rr.Header().Rrtype != rrtype {
+			continue
+		}
+		rr.Header().Name = name
+		records = append(records, rr)
+	}
+	return records, nil
+}
+
+// ConvertToWire rewrites the JSON fields below zones in wire format and
+// returns the number of fields converted. Fields with items that wire format
+// can't express, a window or a health check, are left as they are.
+func (s *Store) ConvertToWire(ctx context.Context, zones ...string) (int, error) {
+	converted := 0
+	for _, zone := range zones {
+		err := s.layout.walk(ctx, dns.Fqdn(zone), func(key string, fields map[string]string) error {
+			name := keys.Name(key, s.prefix)
+			for field, val := range fields {
+				if !IsJSON(val) || strings.Contains(val, `"not_before"`) || strings.Contains(val, `"not_after"`) || strings.Contains(val, `"check"`) {
+					continue
+				}
+				records, err := ItemRecords(field, val, name)
+				if err != nil {
+					continue
+				}
+				wire, err := PackWire(records)
+				if err != nil {
+					return err
+				}
+				_, err = s.client.Pipelined(ctx, func(pipe redisV8.Pipeliner) error {
+					return s.layout.set(ctx, pipe, key, field, wire)
+				})
+				if err != nil {
+					return err
+				}
+				converted++
+			}
+			return nil
+		})
+		if err != nil {
+			return converted, err
+		}
+	}
+	return converted, nil
+}
@@ -0,0 +1,76 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+var wireTests = []struct {
+	field string
+	qtype uint16
+	val   string
+}{
+	{"A", dns.TypeA, `[{"ttl":30,"ip":"1.1.1.1"},{"ttl":30,"ip":"1.1.1.2"},{"ttl":30,"ip":"1.1.1.3"},{"ttl":30,"ip":"1.1.1.4"}]`},
+	{"MX", dns.TypeMX, `[{"ttl":10,"host":"mail.example.net","preference":10},{"ttl":10,"host":"mail2.example.net","preference":20}]`},
+	{"NS", dns.TypeNS, `[{"ttl":300,"host":"ns1.example.net"},{"ttl":300,"host":"ns2.example.net"}]`},
+	{"TXT", dns.TypeTXT, `[{"ttl":30,"text":"v=spf1 include:_spf.example.net ~all"}]`},
+	{"SRV", dns.TypeSRV, `[{"ttl":10,"priority":10,"weight":1,"port":8080,"target":"srv1.example.net"},{"ttl":10,"priority":10,"weight":2,"port":8081,"target":"srv2.example.net"}]`},
+}
+
+func TestWire(t *testing.T) {
+	for _, tc := range wireTests {
+		records, err := ItemRecords(tc.field, tc.val, "www.example.net.")
+		if err != nil {
+			t.Fatalf("%s: %s", tc.field, err)
+		}
+		wire, err := PackWire(records)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.field, err)
+		}
+		if !IsWire(wire) || IsJSON(wire) {
+			t.Errorf("%s: wire value not detected", tc.field)
+		}
+
+		unpacked, err := unpackWire(wire, "www.example.net.", tc.qtype)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.field, err)
+		}
+		if len(unpacked) != len(records) {
+			t.Fatalf("%s: expected %d records, got %d", tc.field, len(records), len(unpacked))
+		}
+		for i := range records {
+			if !dns.IsDuplicate(records[i], unpacked[i]) || records[i].Header().Ttl != unpacked[i].Header().Ttl {
+				t.Errorf("%s: expected %s, got %s", tc.field, records[i], unpacked[i])
+			}
+		}
+	}
+}
+
+func BenchmarkDecodeJSON(b *testing.B) {
+	for _, tc := range wireTests {
+		b.Run(tc.field, func(b *testing.B) {
+			b.ReportMetric(float64(len(tc.val)), "bytes/value")
+			for i := 0; i < b.N; i++ {
+				if _, err := ItemRecords(tc.field, tc.val, "www.example.net."); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecodeWire(b *testing.B) {
+	for _, tc := range wireTests {
+		records, _ := ItemRecords(tc.field, tc.val, "www.example.net.")
+		wire, _ := PackWire(records)
+		b.Run(tc.field, func(b *testing.B) {
+			b.ReportMetric(float64(len(wire)), "bytes/value")
+			for i := 0; i < b.N; i++ {
+				if _, err := unpackWire(wire, "www.example.net.", tc.qtype); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
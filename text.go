@@ -0,0 +1,44 @@
+package redis
+
+import (
+	"context"
+
+	"github.com/coredns/coredns/request"
+	"github.com/kexirong/coredns-redis/store"
+	"github.com/miekg/dns"
+)
+
+// rawRecords decodes a value in wire or presentation format for the question
+// of state.
+func rawRecords(val string, state request.Request, zone string) ([]dns.RR, error) {
+	return store.DecodeRaw(val, dns.Fqdn(state.QName()), zone, state.QType())
+}
+
+// Other answers the types without JSON items, which have to be stored in
+// presentation or wire format, or errKeyNotFound without records of the
+// type.
+func (r Redis) Other(ctx context.Context, zone string, state request.Request) (records []dns.RR, err error) {
+	key := Key(state.Name(), r.KeyPrefix)
+doSearch:
+	val, err := r.get(ctx, key, state.Type())
+	if err != nil {
+		if err == errKeyNotFound && !IsAnyKey(key) {
+			key = wildcardKey(ctx, zone, key)
+			goto doSearch
+		}
+		return nil, err
+	}
+	return rawRecords(val, state, zone)
+}
+
+// nameExists returns nil if name or the wildcard of name has fields, and
+// errKeyNotFound if not.
+func (r Redis) nameExists(ctx context.Context, name string) error {
+	key := Key(name, r.KeyPrefix)
+	for _, k := range []string{key, AnyKey(key)} {
+		if _, err := r.get(ctx, k, ""); err != errKeyNotFound {
+			return err
+		}
+	}
+	return errKeyNotFound
+}
@@ -0,0 +1,28 @@
+package redis
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestOther(t *testing.T) {
+	r, mr := testRedis(t)
+	mr.HSet(Key("www.example.net.", "coredns"), "SSHFP", "3600 IN SSHFP 4 2 123456789abcdef67890123456789abcdef67890123456789abcdef123456789")
+	mr.HSet(Key("mail.example.net.", "coredns"), "A", `[{"ttl":30,"ip":"192.0.2.1"}]`)
+	mr.HSet(Key("*.dev.example.net.", "coredns"), "A", `[{"ttl":30,"ip":"192.0.2.2"}]`)
+
+	records, err := r.Other(ctx, "example.net.", testState("www.example.net.", dns.TypeSSHFP))
+	if err != nil || len(records) != 1 || records[0].(*dns.SSHFP).Algorithm != 4 {
+		t.Errorf("expected the SSHFP, got %v (%v)", records, err)
+	}
+
+	if _, err := r.Other(ctx, "example.net.", testState("mail.example.net.", dns.TypeSSHFP)); err != errKeyNotFound {
+		t.Errorf("expected errKeyNotFound, got %v", err)
+	}
+	for name, want := range map[string]error{"mail.example.net.": nil, "x.dev.example.net.": nil, "nx.example.net.": errKeyNotFound} {
+		if err := r.nameExists(ctx, name); err != want {
+			t.Errorf("nameExists(%s) = %v, want %v", name, err, want)
+		}
+	}
+}
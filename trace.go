@@ -0,0 +1,139 @@
+package redis
+
+import (
+	"context"
+	"strings"
+
+	redisV8 "github.com/go-redis/redis/v8"
+	"github.com/miekg/dns"
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// tracingHook traces the commands sent to redis as children of the span of
+// the query, set by the trace plugin. Without one, it does nothing.
+type tracingHook struct{}
+
+type spanKey struct{}
+
+func (tracingHook) start(ctx context.Context, name string) (context.Context, ot.Span) {
+	parent := ot.SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	span := parent.Tracer().StartSpan(name, ot.ChildOf(parent.Context()))
+	ext.DBType.Set(span, "redis")
+	ext.SpanKindRPCClient.Set(span)
+	// Our own key, commands must not become parents of each other.
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (h tracingHook) BeforeProcess(ctx context.Context, cmd redisV8.Cmder) (context.Context, error) {
+	ctx, span := h.start(ctx, "redis "+cmd.Name())
+	if span != nil {
+		tagCmd(span, cmd)
+	}
+	return ctx, nil
+}
+
+func (tracingHook) AfterProcess(ctx context.Context, cmd redisV8.Cmder) error {
+	if span, ok := ctx.Value(spanKey{}).(ot.Span); ok {
+		result := cmdResult(cmd)
+		if result == "error" {
+			ext.Error.Set(span, true)
+		}
+		span.SetTag("redis.result", result)
+		span.Finish()
+	}
+	return nil
+}
+
+func (h tracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redisV8.Cmder) (context.Context, error) {
+	ctx, span := h.start(ctx, "redis pipeline")
+	if span != nil {
+		names := make([]string, len(cmds))
+		for i, cmd := range cmds {
+			names[i] = cmd.Name()
+		}
+		span.SetTag("redis.commands", strings.Join(names, " "))
+		if len(cmds) > 0 {
+			tagCmd(span, cmds[0])
+		}
+	}
+	return ctx, nil
+}
+
+func (tracingHook) AfterProcessPipeline(ctx context.Context, cmds []redisV8.Cmder) error {
+	if span, ok := ctx.Value(spanKey{}).(ot.Span); ok {
+		result := "hit"
+		for _, cmd := range cmds {
+			switch cmdResult(cmd) {
+			case "error":
+				result = "error"
+			case "miss":
+				if result == "hit" {
+					result = "miss"
+				}
+			}
+		}
+		if result == "error" {
+			ext.Error.Set(span, true)
+		}
+		span.SetTag("redis.result", result)
+		span.Finish()
+	}
+	return nil
+}
+
+// tagCmd sets the key and field of cmd on span, as far as it has them.
+func tagCmd(span ot.Span, cmd redisV8.Cmder) {
+	args := cmd.Args()
+	if len(args) > 1 {
+		span.SetTag("redis.key", args[1])
+	}
+	switch cmd.Name() {
+	case "hget", "hset", "hdel", "hexists":
+		if len(args) > 2 {
+			span.SetTag("redis.field", args[2])
+		}
+	}
+}
+
+func cmdResult(cmd redisV8.Cmder) string {
+	switch err := cmd.Err(); err {
+	case nil:
+		return "hit"
+	case redisV8.Nil:
+		return "miss"
+	default:
+		return "error"
+	}
+}
+
+// traceAnswer tags the span of the query with the CNAMEs chased for the
+// answer.
+func traceAnswer(ctx context.Context, qtype uint16, answer []dns.RR) {
+	if span := ot.SpanFromContext(ctx); span != nil {
+		span.SetTag("redis.cname_hops", cnameHops(qtype, answer))
+	}
+}
+
+// traceUpstream starts a span for an upstream lookup of name, to be finished
+// with its error.
+func traceUpstream(ctx context.Context, name string) func(error) {
+	parent := ot.SpanFromContext(ctx)
+	if parent == nil {
+		return func(error) {}
+	}
+	span := parent.Tracer().StartSpan("redis upstream", ot.ChildOf(parent.Context()))
+	span.SetTag("redis.upstream_name", name)
+	return func(err error) {
+		if err != nil {
+			ext.Error.Set(span, true)
+			span.SetTag("redis.result", "error")
+		} else {
+			span.SetTag("redis.result", "success")
+		}
+		span.Finish()
+	}
+}
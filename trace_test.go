@@ -0,0 +1,44 @@
+package redis
+
+import (
+	"context"
+	"testing"
+
+	redisV8 "github.com/go-redis/redis/v8"
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+func TestTracingHook(t *testing.T) {
+	client := redisV8.NewUniversalClient(&redisV8.UniversalOptions{Addrs: []string{"127.0.0.1:1"}, MaxRetries: -1})
+	defer client.Close()
+	client.AddHook(tracingHook{})
+
+	tracer := mocktracer.New()
+	parent := tracer.StartSpan("servedns")
+	ctx := ot.ContextWithSpan(context.Background(), parent)
+
+	client.HGet(ctx, "coredns:net:example:www", "A")
+	traceUpstream(ctx, "www.example.org.")(nil)
+	// Not traced, no span in the context.
+	client.HGet(context.Background(), "coredns:net:example:www", "AAAA")
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+
+	get := spans[0]
+	if get.OperationName != "redis hget" || get.ParentID != parent.Context().(mocktracer.MockSpanContext).SpanID {
+		t.Errorf("got span %q, parent %d", get.OperationName, get.ParentID)
+	}
+	for tag, want := range map[string]interface{}{"redis.key": "coredns:net:example:www", "redis.field": "A", "redis.result": "error"} {
+		if got := get.Tag(tag); got != want {
+			t.Errorf("tag %s = %v, want %v", tag, got, want)
+		}
+	}
+
+	if up := spans[1]; up.OperationName != "redis upstream" || up.Tag("redis.result") != "success" {
+		t.Errorf("got span %q, result %v", up.OperationName, up.Tag("redis.result"))
+	}
+}
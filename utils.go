@@ -1,48 +1,29 @@
 package redis
 
 import (
-	"strings"
-
-	"github.com/miekg/dns"
+	"github.com/kexirong/coredns-redis/keys"
+	"github.com/kexirong/coredns-redis/store"
 )
 
+// Key returns the key of dn, see package keys for the encoding of labels.
 func Key(dn, prefix string) string {
-	labels := dns.SplitDomainName(dn)
-	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
-		labels[i], labels[j] = labels[j], labels[i]
-	}
-	if prefix != "" {
-		labels = append([]string{prefix}, labels...)
-	}
-	return strings.Join(labels, ":")
+	return keys.Key(dn, prefix)
+}
+
+// keyName is the reverse of Key, it returns the domain name of key.
+func keyName(key, prefix string) string {
+	return keys.Name(key, prefix)
 }
 
 func AnyKey(key string) string {
-	parts := strings.Split(key, ":")
-	parts[len(parts)-1] = "*"
-	return strings.Join(parts, ":")
+	return store.AnyKey(key)
 }
 
 func IsAnyKey(key string) bool {
-	return strings.HasSuffix(key, "*")
+	return store.IsAnyKey(key)
 }
 
 // Split255 splits a string into 255 byte chunks.
 func Split255(s string) []string {
-	if len(s) < 255 {
-		return []string{s}
-	}
-	sx := []string{}
-	p, i := 0, 255
-	for {
-		if i <= len(s) {
-			sx = append(sx, s[p:i])
-		} else {
-			sx = append(sx, s[p:])
-			break
-		}
-		p, i = p+255, i+255
-	}
-
-	return sx
+	return store.Split255(s)
 }